@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -21,13 +22,371 @@ type Config struct {
 	GRPCPort    string `mapstructure:"GRPC_PORT"`
 	SMS_API_KEY string `mapstructure:"SMS_API_KEY"`
 
+	// TrustedProxies lists the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-For header is believed when working out a client's IP for
+	// lockouts and rate limits. With none, the connection's address is used.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+
+	JWT            JWTConfig            `mapstructure:"JWT"`
+	PasswordPolicy PasswordPolicyConfig `mapstructure:"PASSWORD_POLICY"`
+	Lockout        LockoutConfig        `mapstructure:"LOCKOUT"`
+	MFA            MFAConfig            `mapstructure:"MFA"`
+	SMS            SMSConfig            `mapstructure:"SMS"`
+	OTP            OTPConfig            `mapstructure:"OTP"`
+	OTPRateLimit   OTPRateLimitConfig   `mapstructure:"OTP_RATE_LIMIT"`
+	OTPSweeper     OTPSweeperConfig     `mapstructure:"OTP_SWEEPER"`
+	Mail           MailConfig           `mapstructure:"MAIL"`
+	OTPChannels    OTPChannelsConfig    `mapstructure:"OTP_CHANNELS"`
+	Phone          PhoneConfig          `mapstructure:"PHONE"`
+	Events         EventsConfig         `mapstructure:"EVENTS"`
+	Users          UsersConfig          `mapstructure:"USERS"`
+	GRPCAuth       GRPCAuthConfig       `mapstructure:"GRPC_AUTH"`
+
 	CommonConfig `mapstructure:",squash"`
 }
 
+// JWTKeyConfig is one key used to sign or verify tokens. Algorithm is HS256
+// (the default, using Secret), RS256 or ES256 (using PEM files). A key with
+// only a public key file can verify tokens but never sign them. RetiredAt is
+// an RFC 3339 timestamp; once set the key is no longer used for signing and
+// the tokens it signed stop validating after JWTConfig.RetiredKeyGrace.
+type JWTKeyConfig struct {
+	ID             string `mapstructure:"ID"`
+	Algorithm      string `mapstructure:"ALGORITHM"`
+	Secret         string `mapstructure:"SECRET"`
+	PrivateKeyFile string `mapstructure:"PRIVATE_KEY_FILE"`
+	PublicKeyFile  string `mapstructure:"PUBLIC_KEY_FILE"`
+	RetiredAt      string `mapstructure:"RETIRED_AT"`
+}
+
+type JWTConfig struct {
+	SigningKeyID    string         `mapstructure:"SIGNING_KEY_ID"`
+	Keys            []JWTKeyConfig `mapstructure:"KEYS"`
+	RetiredKeyGrace time.Duration  `mapstructure:"RETIRED_KEY_GRACE"`
+	AccessTokenTTL  time.Duration  `mapstructure:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration  `mapstructure:"REFRESH_TOKEN_TTL"`
+
+	// Issuer and Audience are stamped on every token we sign and required on
+	// every token we accept. Leeway absorbs clock skew between services when
+	// checking exp, nbf and iat. Algorithms limits the accepted alg headers;
+	// when empty, the algorithms of the configured keys are accepted.
+	Issuer     string        `mapstructure:"ISSUER"`
+	Audience   string        `mapstructure:"AUDIENCE"`
+	Leeway     time.Duration `mapstructure:"LEEWAY"`
+	Algorithms []string      `mapstructure:"ALGORITHMS"`
+
+	// JWKSURL, when set, makes tokens be verified against the JWKS document
+	// published there instead of the configured keys, as services that only
+	// consume our tokens do. The document is fetched again every
+	// JWKSRefreshInterval, and early for an unknown kid at most once per
+	// JWKSMinRefreshInterval.
+	JWKSURL                string        `mapstructure:"JWKS_URL"`
+	JWKSRefreshInterval    time.Duration `mapstructure:"JWKS_REFRESH_INTERVAL"`
+	JWKSMinRefreshInterval time.Duration `mapstructure:"JWKS_MIN_REFRESH_INTERVAL"`
+}
+
+// PasswordPolicyConfig applies whenever a user sets a new password.
+// CommonPasswordsFile is an optional newline-separated list of breached or
+// common passwords to reject.
+type PasswordPolicyConfig struct {
+	MinLength           int    `mapstructure:"MIN_LENGTH"`
+	CommonPasswordsFile string `mapstructure:"COMMON_PASSWORDS_FILE"`
+}
+
+// LockoutConfig throttles password sign in. After each failed attempt on an
+// account the next one is delayed by BaseDelay, doubling up to MaxDelay.
+// Reaching MaxAccountFailures on an account or MaxIPFailures from a client IP
+// locks it out for LockoutDuration. Failures older than FailureWindow are
+// forgotten.
+type LockoutConfig struct {
+	MaxAccountFailures int           `mapstructure:"MAX_ACCOUNT_FAILURES"`
+	MaxIPFailures      int           `mapstructure:"MAX_IP_FAILURES"`
+	LockoutDuration    time.Duration `mapstructure:"LOCKOUT_DURATION"`
+	BaseDelay          time.Duration `mapstructure:"BASE_DELAY"`
+	MaxDelay           time.Duration `mapstructure:"MAX_DELAY"`
+	FailureWindow      time.Duration `mapstructure:"FAILURE_WINDOW"`
+}
+
+// MFAConfig controls TOTP two-factor authentication. Issuer is the name shown
+// in authenticator apps. A sign in challenge expires after ChallengeTTL or
+// MaxChallengeAttempts wrong codes. Skew is how many 30 second steps either
+// side of the current one are accepted. RequiredRoles are only carried by
+// tokens from sessions that passed two-factor authentication, so users with
+// them sign in without them until they enable it.
+type MFAConfig struct {
+	Issuer               string        `mapstructure:"ISSUER"`
+	ChallengeTTL         time.Duration `mapstructure:"CHALLENGE_TTL"`
+	MaxChallengeAttempts int           `mapstructure:"MAX_CHALLENGE_ATTEMPTS"`
+	RecoveryCodes        int           `mapstructure:"RECOVERY_CODES"`
+	Skew                 int           `mapstructure:"SKEW"`
+	RequiredRoles        []string      `mapstructure:"REQUIRED_ROLES"`
+}
+
+// SMSConfig selects how OTPs are delivered. Provider is "2factor", "twilio",
+// "console" or "file" (development, writing to File), or "recording" (tests).
+// Message is the text sent by providers that don't use templates, with %s
+// replaced by the code. TwoFactorAPIKey falls back to SMS_API_KEY.
+type SMSConfig struct {
+	Provider          string        `mapstructure:"PROVIDER"`
+	Message           string        `mapstructure:"MESSAGE"`
+	Timeout           time.Duration `mapstructure:"TIMEOUT"`
+	TwoFactorAPIKey   string        `mapstructure:"TWOFACTOR_API_KEY"`
+	TwoFactorTemplate string        `mapstructure:"TWOFACTOR_TEMPLATE"`
+	TwilioBaseURL     string        `mapstructure:"TWILIO_BASE_URL"`
+	TwilioAccountSID  string        `mapstructure:"TWILIO_ACCOUNT_SID"`
+	TwilioAuthToken   string        `mapstructure:"TWILIO_AUTH_TOKEN"`
+	TwilioFrom        string        `mapstructure:"TWILIO_FROM"`
+	File              string        `mapstructure:"FILE"`
+}
+
+// PhoneConfig sets how phone numbers are read. Numbers given without a
+// country code are taken to be in DefaultRegion, an ISO 3166 code like "IN".
+//
+// ConfirmOldPhone makes a phone change also need an OTP sent to the
+// account's current number.
+type PhoneConfig struct {
+	DefaultRegion   string `mapstructure:"DEFAULT_REGION"`
+	ConfirmOldPhone bool   `mapstructure:"CONFIRM_OLD_PHONE"`
+}
+
+// EventsConfig sets where account events, such as phone number changes, are
+// published. Publisher is "kafka", producing to Topic, or "log" (development,
+// writing them to stdout).
+type EventsConfig struct {
+	Publisher string `mapstructure:"PUBLISHER"`
+	Topic     string `mapstructure:"TOPIC"`
+}
+
+// UsersConfig limits how many users a batch lookup may ask for at once.
+type UsersConfig struct {
+	BatchMaxIDs int `mapstructure:"BATCH_MAX_IDS"`
+}
+
+// GRPCAuthConfig lists the tokens other backend services send in the
+// "x-service-token" metadata of gRPC calls made on their own behalf. Such
+// calls may act on any user; calls with neither a service token nor a user's
+// bearer token are rejected.
+type GRPCAuthConfig struct {
+	ServiceTokens []string `mapstructure:"SERVICE_TOKENS"`
+}
+
+// OTPChannelsConfig lists the channels phone OTPs can be sent over, in the
+// order they are tried when one fails. "sms" uses the SMS settings;
+// "whatsapp" and "voice" need a Provider in their own settings.
+type OTPChannelsConfig struct {
+	Order    []string       `mapstructure:"ORDER"`
+	WhatsApp WhatsAppConfig `mapstructure:"WHATSAPP"`
+	Voice    VoiceConfig    `mapstructure:"VOICE"`
+}
+
+// WhatsAppConfig selects how OTPs are sent over WhatsApp. Provider is "meta"
+// (the WhatsApp Cloud API, sending the approved authentication Template with
+// the code as its parameter), "twilio" (the SMS Twilio account, sending From
+// a WhatsApp enabled number), "console" or "recording".
+type WhatsAppConfig struct {
+	Provider      string `mapstructure:"PROVIDER"`
+	BaseURL       string `mapstructure:"BASE_URL"`
+	PhoneNumberID string `mapstructure:"PHONE_NUMBER_ID"`
+	AccessToken   string `mapstructure:"ACCESS_TOKEN"`
+	Template      string `mapstructure:"TEMPLATE"`
+	Language      string `mapstructure:"LANGUAGE"`
+	From          string `mapstructure:"FROM"`
+}
+
+// VoiceConfig selects how OTPs are read out in a phone call. Provider is
+// "2factor" (the SMS 2factor account), "twilio" (the SMS Twilio account,
+// calling From), "console" or "recording". Message is what Twilio reads out,
+// with %s replaced by the digits of the code.
+type VoiceConfig struct {
+	Provider string `mapstructure:"PROVIDER"`
+	Message  string `mapstructure:"MESSAGE"`
+	From     string `mapstructure:"FROM"`
+}
+
+// OTPPurposeConfig sets the number of digits and lifetime of OTPs issued for
+// one purpose.
+type OTPPurposeConfig struct {
+	Length int           `mapstructure:"LENGTH"`
+	TTL    time.Duration `mapstructure:"TTL"`
+}
+
+// OTPConfig controls OTP generation and verification. Codes are stored as an
+// HMAC keyed with Secret, which must not be shared with the JWT keys. An OTP
+// is deleted once MaxAttempts wrong codes have been tried against it.
+// ReviewPhones never receive a sign in SMS; their sign in code is always
+// ReviewCode, so app store reviewers can sign in.
+type OTPConfig struct {
+	Secret        string           `mapstructure:"SECRET"`
+	MaxAttempts   int              `mapstructure:"MAX_ATTEMPTS"`
+	Login         OTPPurposeConfig `mapstructure:"LOGIN"`
+	PhoneChange   OTPPurposeConfig `mapstructure:"PHONE_CHANGE"`
+	PasswordReset OTPPurposeConfig `mapstructure:"PASSWORD_RESET"`
+	EmailLogin    OTPPurposeConfig `mapstructure:"EMAIL_LOGIN"`
+	MagicLink     OTPPurposeConfig `mapstructure:"MAGIC_LINK"`
+	EmailChange   OTPPurposeConfig `mapstructure:"EMAIL_CHANGE"`
+	ReviewPhones  []string         `mapstructure:"REVIEW_PHONES"`
+	ReviewCode    string           `mapstructure:"REVIEW_CODE"`
+}
+
+// ForPurpose returns the settings for OTPs issued for purpose, falling back
+// to the login settings.
+func (c OTPConfig) ForPurpose(purpose string) OTPPurposeConfig {
+	switch purpose {
+	case "phone_change":
+		return c.PhoneChange
+	case "password_reset":
+		return c.PasswordReset
+	case "email_login":
+		return c.EmailLogin
+	case "magic_link":
+		return c.MagicLink
+	case "email_change":
+		return c.EmailChange
+	default:
+		return c.Login
+	}
+}
+
+// MaxTTL returns the longest lifetime of an OTP for any purpose.
+func (c OTPConfig) MaxTTL() time.Duration {
+	var ttl time.Duration
+	for _, purpose := range []OTPPurposeConfig{c.Login, c.PhoneChange, c.PasswordReset, c.EmailLogin, c.MagicLink, c.EmailChange} {
+		if purpose.TTL > ttl {
+			ttl = purpose.TTL
+		}
+	}
+
+	return ttl
+}
+
+// ReviewCodeFor returns the fixed code for phone if it is a review phone and
+// the OTP is for purpose "login". Other purposes, such as password resets
+// and phone changes, always get a real code, so knowing the review code
+// isn't enough to take the review account over.
+func (c OTPConfig) ReviewCodeFor(phone string, purpose string) (string, bool) {
+	if c.ReviewCode == "" || purpose != "login" {
+		return "", false
+	}
+	for _, reviewPhone := range c.ReviewPhones {
+		if reviewPhone == phone {
+			return c.ReviewCode, true
+		}
+	}
+
+	return "", false
+}
+
+// OTPRateLimitConfig limits how many OTPs are sent to a recipient and
+// requested from a client IP. The Phone limits apply to email addresses too.
+// A limit of 0 disables it. ResendCooldown is the minimum time between two
+// OTPs to the same recipient. Store is "database" (shared between replicas)
+// or "memory".
+type OTPRateLimitConfig struct {
+	Store          string        `mapstructure:"STORE"`
+	ResendCooldown time.Duration `mapstructure:"RESEND_COOLDOWN"`
+	PhonePerHour   int           `mapstructure:"PHONE_PER_HOUR"`
+	PhonePerDay    int           `mapstructure:"PHONE_PER_DAY"`
+	IPPerHour      int           `mapstructure:"IP_PER_HOUR"`
+	IPPerDay       int           `mapstructure:"IP_PER_DAY"`
+}
+
+// OTPSweeperConfig controls the background job deleting OTPs, MFA
+// challenges, refresh token families, revoked tokens and rate limited OTP
+// sends that expired more than Retention ago. It runs every Interval,
+// deleting at most BatchSize rows per statement.
+type OTPSweeperConfig struct {
+	Enabled   bool          `mapstructure:"ENABLED"`
+	Interval  time.Duration `mapstructure:"INTERVAL"`
+	Retention time.Duration `mapstructure:"RETENTION"`
+	BatchSize int           `mapstructure:"BATCH_SIZE"`
+}
+
+// MailConfig selects how email is sent. Provider is "smtp", "spool" (writes
+// .eml files to SpoolDir, for development) or "recording" (tests).
+// MagicLinkURL is the page magic links point to, and EmailChangeURL the page
+// links confirming a new address point to; the verification_id and token
+// query parameters are appended to them. Opening either page does nothing
+// until the user submits it, since mail scanners open links too.
+type MailConfig struct {
+	Provider       string `mapstructure:"PROVIDER"`
+	From           string `mapstructure:"FROM"`
+	SMTPHost       string `mapstructure:"SMTP_HOST"`
+	SMTPPort       int    `mapstructure:"SMTP_PORT"`
+	SMTPUsername   string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword   string `mapstructure:"SMTP_PASSWORD"`
+	SpoolDir       string `mapstructure:"SPOOL_DIR"`
+	MagicLinkURL   string `mapstructure:"MAGIC_LINK_URL"`
+	EmailChangeURL string `mapstructure:"EMAIL_CHANGE_URL"`
+}
+
 func LoadConfig() (*Config, error) {
 	viper.SetConfigFile("./config/config.yaml")
 	// viper.SetConfigFile("/go/src/app/config/config.yaml")
 
+	viper.SetDefault("JWT.ACCESS_TOKEN_TTL", "15m")
+	viper.SetDefault("JWT.REFRESH_TOKEN_TTL", "720h")
+	viper.SetDefault("JWT.ISSUER", "openzo-user")
+	viper.SetDefault("JWT.AUDIENCE", "openzo")
+	viper.SetDefault("JWT.LEEWAY", "30s")
+	viper.SetDefault("JWT.JWKS_REFRESH_INTERVAL", "1h")
+	viper.SetDefault("JWT.JWKS_MIN_REFRESH_INTERVAL", "1m")
+	viper.SetDefault("PASSWORD_POLICY.MIN_LENGTH", 8)
+	viper.SetDefault("LOCKOUT.MAX_ACCOUNT_FAILURES", 5)
+	viper.SetDefault("LOCKOUT.MAX_IP_FAILURES", 20)
+	viper.SetDefault("LOCKOUT.LOCKOUT_DURATION", "15m")
+	viper.SetDefault("LOCKOUT.BASE_DELAY", "1s")
+	viper.SetDefault("LOCKOUT.MAX_DELAY", "30s")
+	viper.SetDefault("LOCKOUT.FAILURE_WINDOW", "1h")
+	viper.SetDefault("MFA.ISSUER", "Openzo")
+	viper.SetDefault("MFA.CHALLENGE_TTL", "5m")
+	viper.SetDefault("MFA.MAX_CHALLENGE_ATTEMPTS", 5)
+	viper.SetDefault("MFA.RECOVERY_CODES", 10)
+	viper.SetDefault("MFA.SKEW", 1)
+	viper.SetDefault("MFA.REQUIRED_ROLES", []string{"ADMIN", "STORE_OWNER"})
+	viper.SetDefault("SMS.PROVIDER", "2factor")
+	viper.SetDefault("SMS.MESSAGE", "Your Openzo verification code is %s")
+	viper.SetDefault("SMS.TIMEOUT", "10s")
+	viper.SetDefault("SMS.TWOFACTOR_TEMPLATE", "OTP 1")
+	viper.SetDefault("SMS.TWILIO_BASE_URL", "https://api.twilio.com")
+	viper.SetDefault("SMS.FILE", "sms.log")
+	viper.SetDefault("OTP.MAX_ATTEMPTS", 5)
+	viper.SetDefault("OTP.LOGIN.LENGTH", 4)
+	viper.SetDefault("OTP.LOGIN.TTL", "5m")
+	viper.SetDefault("OTP.PHONE_CHANGE.LENGTH", 6)
+	viper.SetDefault("OTP.PHONE_CHANGE.TTL", "10m")
+	viper.SetDefault("OTP.PASSWORD_RESET.LENGTH", 6)
+	viper.SetDefault("OTP.PASSWORD_RESET.TTL", "10m")
+	viper.SetDefault("OTP.EMAIL_LOGIN.LENGTH", 6)
+	viper.SetDefault("OTP.EMAIL_LOGIN.TTL", "10m")
+	viper.SetDefault("OTP.MAGIC_LINK.TTL", "15m")
+	viper.SetDefault("OTP.EMAIL_CHANGE.TTL", "24h")
+	viper.SetDefault("OTP_RATE_LIMIT.STORE", "database")
+	viper.SetDefault("OTP_RATE_LIMIT.RESEND_COOLDOWN", "30s")
+	viper.SetDefault("OTP_RATE_LIMIT.PHONE_PER_HOUR", 5)
+	viper.SetDefault("OTP_RATE_LIMIT.PHONE_PER_DAY", 10)
+	viper.SetDefault("OTP_RATE_LIMIT.IP_PER_HOUR", 20)
+	viper.SetDefault("OTP_RATE_LIMIT.IP_PER_DAY", 100)
+	viper.SetDefault("OTP_SWEEPER.ENABLED", true)
+	viper.SetDefault("OTP_SWEEPER.INTERVAL", "10m")
+	viper.SetDefault("OTP_SWEEPER.RETENTION", "24h")
+	viper.SetDefault("OTP_SWEEPER.BATCH_SIZE", 500)
+	viper.SetDefault("OTP_CHANNELS.ORDER", []string{"sms"})
+	viper.SetDefault("PHONE.DEFAULT_REGION", "IN")
+	viper.SetDefault("PHONE.CONFIRM_OLD_PHONE", true)
+	viper.SetDefault("EVENTS.PUBLISHER", "log")
+	viper.SetDefault("EVENTS.TOPIC", "user-events")
+	viper.SetDefault("USERS.BATCH_MAX_IDS", 500)
+	viper.SetDefault("OTP_CHANNELS.WHATSAPP.BASE_URL", "https://graph.facebook.com/v19.0")
+	viper.SetDefault("OTP_CHANNELS.WHATSAPP.TEMPLATE", "otp")
+	viper.SetDefault("OTP_CHANNELS.WHATSAPP.LANGUAGE", "en")
+	viper.SetDefault("OTP_CHANNELS.VOICE.MESSAGE", "Your Openzo verification code is %s")
+	viper.SetDefault("MAIL.PROVIDER", "spool")
+	viper.SetDefault("MAIL.FROM", "Openzo <no-reply@openzo.in>")
+	viper.SetDefault("MAIL.SMTP_PORT", 587)
+	viper.SetDefault("MAIL.SPOOL_DIR", "mail")
+	viper.SetDefault("MAIL.MAGIC_LINK_URL", "http://localhost:8080/email/magic-link/verify")
+	viper.SetDefault("MAIL.EMAIL_CHANGE_URL", "http://localhost:8080/email/change/verify")
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -53,7 +412,9 @@ func LoadConfig() (*Config, error) {
 
 	Config.CommonConfig = commonConfig
 
-	log.Printf("Config: %+v", Config)
+	// The rest of the config holds secrets, such as signing keys and
+	// provider credentials, so only where the service runs is logged.
+	log.Printf("Config: mode %s, http port %s, grpc port %s", Config.MODE, Config.HTTPPort, Config.GRPCPort)
 
 	return &Config, nil
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/tanush-128/openzo_backend/user/config"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
 	"gorm.io/driver/sqlite"
 
 	"gorm.io/driver/mysql"
@@ -18,7 +19,7 @@ func connectToDB(cfg *config.Config) (*gorm.DB, error) {
 	if cfg.MODE == "production" {
 		dsn := cfg.DB_URL
 
-		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 		if err != nil {
 			return nil, fmt.Errorf("failed to open database connection: %w", err)
 		}
@@ -28,17 +29,82 @@ func connectToDB(cfg *config.Config) (*gorm.DB, error) {
 		db, err = gorm.Open(
 			sqlite.Open("test.db"),
 
-			&gorm.Config{},
+			&gorm.Config{TranslateError: true},
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open database connection: %w", err)
 		}
 	}
 
+	return db, nil
+}
+
+// migrateDB creates the tables, the unique user phone and email indexes and
+// the default roles.
+func migrateDB(db *gorm.DB) error {
 	db.Migrator().AutoMigrate(&models.User{})
+	if err := ensurePhoneIndex(db); err != nil {
+		return fmt.Errorf("failed to index user phone numbers, run normalize-phones to find duplicates: %w", err)
+	}
+	if err := ensureEmailIndex(db); err != nil {
+		return fmt.Errorf("failed to index user emails: %w", err)
+	}
 
 	db.Migrator().AutoMigrate(&models.OTP{})
+	db.Migrator().AutoMigrate(&models.OTPSend{})
 	db.Migrator().AutoMigrate(&models.Address{})
+	db.Migrator().AutoMigrate(&models.RefreshToken{})
+	db.Migrator().AutoMigrate(&models.RevokedToken{})
+	db.Migrator().AutoMigrate(&models.UserRevocation{})
+	db.Migrator().AutoMigrate(&models.Role{})
+	db.Migrator().AutoMigrate(&models.LoginThrottle{})
+	db.Migrator().AutoMigrate(&models.TOTPSecret{})
+	db.Migrator().AutoMigrate(&models.RecoveryCode{})
+	db.Migrator().AutoMigrate(&models.MFAChallenge{})
+	db.Migrator().AutoMigrate(&models.PhoneChange{})
 
-	return db, nil
+	roleRepository := repository.NewRoleRepository(db)
+	if err := roleRepository.EnsureRoles(models.DefaultRoles); err != nil {
+		return fmt.Errorf("failed to seed roles: %w", err)
+	}
+	if err := roleRepository.BackfillUserRoles(); err != nil {
+		return fmt.Errorf("failed to backfill user roles: %w", err)
+	}
+
+	return nil
+}
+
+// ensurePhoneIndex makes phone numbers unique among the users that have one.
+// Users without a number have an empty phone, which the index leaves out. On
+// MySQL that takes a functional index, so MySQL 8.0.13 or later is required.
+func ensurePhoneIndex(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.User{}, "idx_users_phone") {
+		return nil
+	}
+
+	if db.Dialector.Name() == "mysql" {
+		return db.Exec("CREATE UNIQUE INDEX idx_users_phone ON users ((NULLIF(phone, '')))").Error
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX idx_users_phone ON users (phone) WHERE phone <> ''").Error
+}
+
+// ensureEmailIndex makes emails unique among the users that have one. Emails
+// are stored in lower case, so existing ones are lowered first; users that
+// only differ in case have to be merged by hand before the index can exist.
+func ensureEmailIndex(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.User{}, "idx_users_email") {
+		return nil
+	}
+
+	err := db.Model(&models.User{}).Where("email = ''").Update("email", nil).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&models.User{}).Where("email IS NOT NULL").Update("email", gorm.Expr("LOWER(email)")).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX idx_users_email ON users (email)").Error
 }
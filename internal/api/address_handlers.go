@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
 	"github.com/tanush-128/openzo_backend/user/internal/service"
 )
@@ -23,9 +25,9 @@ func (h *AddressHandler) CreateAddress(ctx *gin.Context) {
 		return
 	}
 
-	createdAddress, err := h.addressService.CreateAddress(ctx, address)
+	createdAddress, err := h.addressService.CreateAddress(ctx, ctx.MustGet("user").(*middlewares.Claims), address)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -35,9 +37,9 @@ func (h *AddressHandler) CreateAddress(ctx *gin.Context) {
 func (h *AddressHandler) GetAddressByID(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	address, err := h.addressService.GetAddressByID(ctx, id)
+	address, err := h.addressService.GetAddressByID(ctx, ctx.MustGet("user").(*middlewares.Claims), id)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -47,9 +49,9 @@ func (h *AddressHandler) GetAddressByID(ctx *gin.Context) {
 func (h *AddressHandler) GetAddressesByUserID(ctx *gin.Context) {
 	user_id := ctx.Param("user_id")
 
-	address, err := h.addressService.GetAddressesByUserId(ctx, user_id)
+	address, err := h.addressService.GetAddressesByUserId(ctx, ctx.MustGet("user").(*middlewares.Claims), user_id)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -63,11 +65,22 @@ func (h *AddressHandler) UpdateAddress(ctx *gin.Context) {
 		return
 	}
 
-	updatedAddress, err := h.addressService.UpdateAddress(ctx, address)
+	updatedAddress, err := h.addressService.UpdateAddress(ctx, ctx.MustGet("user").(*middlewares.Claims), address)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, updatedAddress)
 }
+
+func addressErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrAddressNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type EmailOTPRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type EmailOTPVerifyRequest struct {
+	Email          string `json:"email" binding:"required"`
+	OTP            string `json:"otp" binding:"required"`
+	VerificationId string `json:"verification_id" binding:"required"`
+}
+
+// EmailLinkRequest is the verification id and token of an emailed link,
+// posted back by the page the link opens.
+type EmailLinkRequest struct {
+	VerificationId string `form:"verification_id" json:"verification_id" binding:"required"`
+	Token          string `form:"token" json:"token" binding:"required"`
+}
+
+var emailLinkPage = template.Must(template.New("email-link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Title}}</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="verification_id" value="{{.VerificationId}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Title}}</button>
+</form>
+</body>
+</html>
+`))
+
+// showEmailLinkPage answers the GET an emailed link makes with a page that
+// posts its verification id and token back. Mail scanners open links to
+// check them, so opening one must not use up its token.
+func showEmailLinkPage(ctx *gin.Context, title string) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	emailLinkPage.Execute(ctx.Writer, gin.H{
+		"Title":          title,
+		"Action":         ctx.Request.URL.Path,
+		"VerificationId": ctx.Query("verification_id"),
+		"Token":          ctx.Query("token"),
+	})
+}
+
+func (h *OTPHandler) GenerateEmailOTP(ctx *gin.Context) {
+	var emailOTPRequest EmailOTPRequest
+	if err := ctx.BindJSON(&emailOTPRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	verificationId, err := h.otpService.GenerateEmailOTP(ctx, emailOTPRequest.Email)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if err != nil {
+		ctx.JSON(emailOTPErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"verification_id": verificationId})
+}
+
+func (h *OTPHandler) VerifyEmailOTP(ctx *gin.Context) {
+	var emailOTPVerifyRequest EmailOTPVerifyRequest
+	if err := ctx.BindJSON(&emailOTPVerifyRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.otpService.VerifyEmailOTP(ctx, emailOTPVerifyRequest.Email, emailOTPVerifyRequest.VerificationId, emailOTPVerifyRequest.OTP)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSignIn(ctx, result)
+}
+
+func (h *OTPHandler) SendMagicLink(ctx *gin.Context) {
+	var emailOTPRequest EmailOTPRequest
+	if err := ctx.BindJSON(&emailOTPRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.otpService.SendMagicLink(ctx, emailOTPRequest.Email)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if err != nil {
+		ctx.JSON(emailOTPErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *OTPHandler) ShowMagicLink(ctx *gin.Context) {
+	showEmailLinkPage(ctx, "Sign in to Openzo")
+}
+
+func (h *OTPHandler) VerifyMagicLink(ctx *gin.Context) {
+	var emailLinkRequest EmailLinkRequest
+	if err := ctx.ShouldBind(&emailLinkRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.otpService.VerifyMagicLink(ctx, emailLinkRequest.VerificationId, emailLinkRequest.Token)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSignIn(ctx, result)
+}
+
+func (h *OTPHandler) RequestEmailChange(ctx *gin.Context) {
+	var emailChangeRequest service.EmailChangeRequest
+	if err := ctx.BindJSON(&emailChangeRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	err := h.otpService.RequestEmailChange(ctx, claims.UserID, emailChangeRequest.Email)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if err != nil {
+		ctx.JSON(emailOTPErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusAccepted)
+}
+
+func (h *OTPHandler) ShowEmailChange(ctx *gin.Context) {
+	showEmailLinkPage(ctx, "Confirm your Openzo email")
+}
+
+func (h *OTPHandler) ConfirmEmailChange(ctx *gin.Context) {
+	var emailLinkRequest EmailLinkRequest
+	if err := ctx.ShouldBind(&emailLinkRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.otpService.ConfirmEmailChange(ctx, emailLinkRequest.VerificationId, emailLinkRequest.Token)
+	if errors.Is(err, service.ErrEmailInUse) || errors.Is(err, service.ErrEmailChangeSuperseded) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+func emailOTPErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrEmailInUse):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrEmailUnchanged), errors.Is(err, service.ErrInvalidEmail):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrOTPDeliveryFailed):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/service"
 )
 
@@ -17,21 +20,29 @@ func NewHandler(userService *service.UserService) *Handler {
 }
 
 func (h *Handler) CreateUser(ctx *gin.Context) {
-	var user models.User
-	if err := ctx.BindJSON(&user); err != nil {
+	var req service.CreateUserRequest
+	if err := ctx.BindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	user := req.User
+	user.Password = req.Password
 
-	createdUser, token, err := h.userService.CreateUser(ctx, user)
+	createdUser, tokens, err := h.userService.CreateUser(ctx, user)
+	if errors.Is(err, phonenumber.ErrInvalid) || isPasswordPolicyError(err) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusCreated, gin.H{
-		"user":  createdUser,
-		"token": token,
+		"user":          createdUser,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -47,6 +58,28 @@ func (h *Handler) GetUserByID(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, user)
 }
 
+// BatchGetUsers returns the users with the requested ids as a map keyed by
+// id. Unknown ids are missing from the map.
+func (h *Handler) BatchGetUsers(ctx *gin.Context) {
+	var req service.BatchGetUsersRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	users, err := h.userService.GetUsersByIDs(ctx, req.IDs)
+	if errors.Is(err, service.ErrTooManyUserIDs) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"users": users})
+}
+
 func (h *Handler) GetUserByEmail(ctx *gin.Context) {
 	email := ctx.Param("email")
 
@@ -66,9 +99,25 @@ func (h *Handler) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+	if user.ID == "" {
+		user.ID = claims.UserID
+	}
+	if !claims.IsSelfOrRole(user.ID, models.RoleAdmin) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
 	updatedUser, err := h.userService.UpdateUser(ctx, user)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if errors.Is(err, service.ErrUnknownPincode) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(emailOTPErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -82,13 +131,37 @@ func (h *Handler) UserSignIn(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.userService.UserSignIn(ctx, user)
-	if err != nil {
+	result, err := h.userService.UserSignIn(ctx, user)
+	switch {
+	case respondThrottled(ctx, err):
+		return
+	case errors.Is(err, service.ErrInvalidCredentials):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrPasswordNotSet), errors.Is(err, phonenumber.ErrInvalid):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"token": token})
+	respondSignIn(ctx, result)
+}
+
+// respondSignIn writes the tokens of a completed sign in or, when a second
+// factor is needed, the challenge to complete at /signin/mfa.
+func respondSignIn(ctx *gin.Context, result service.SignInResult) {
+	if result.Challenge != nil {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"mfa_required": true,
+			"mfa_token":    result.Challenge.MFAToken,
+			"expires_in":   result.Challenge.ExpiresIn,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result.Tokens)
 }
 
 func (h *Handler) GetUserWithJWT(ctx *gin.Context) {
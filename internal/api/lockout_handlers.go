@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type LockoutHandler struct {
+	lockoutService service.LockoutService
+}
+
+func NewLockoutHandler(lockoutService *service.LockoutService) *LockoutHandler {
+	return &LockoutHandler{lockoutService: *lockoutService}
+}
+
+func (h *LockoutHandler) GetLockouts(ctx *gin.Context) {
+	lockouts, err := h.lockoutService.GetLockouts(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lockouts)
+}
+
+func (h *LockoutHandler) ClearAccountLockout(ctx *gin.Context) {
+	h.clearLockout(ctx, models.ThrottleScopeAccount, ctx.Param("user_id"))
+}
+
+func (h *LockoutHandler) ClearIPLockout(ctx *gin.Context) {
+	h.clearLockout(ctx, models.ThrottleScopeIP, ctx.Param("ip"))
+}
+
+func (h *LockoutHandler) clearLockout(ctx *gin.Context, scope string, subject string) {
+	err := h.lockoutService.ClearLockout(ctx, scope, subject)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// respondThrottled answers 429 with a Retry-After header if err is a
+// *service.ThrottledError and reports whether it did.
+func respondThrottled(ctx *gin.Context, err error) bool {
+	var throttled *service.ThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": retryAfter})
+
+	return true
+}
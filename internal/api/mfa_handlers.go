@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type MFAHandler struct {
+	mfaService service.MFAService
+}
+
+func NewMFAHandler(mfaService *service.MFAService) *MFAHandler {
+	return &MFAHandler{mfaService: *mfaService}
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *MFAHandler) EnrollTOTP(ctx *gin.Context) {
+	enrollment, err := h.mfaService.EnrollTOTP(ctx)
+	if err != nil {
+		ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+func (h *MFAHandler) ConfirmTOTP(ctx *gin.Context) {
+	var confirmTOTPRequest ConfirmTOTPRequest
+	if err := ctx.BindJSON(&confirmTOTPRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := h.mfaService.ConfirmTOTP(ctx, confirmTOTPRequest.Code)
+	if err != nil {
+		ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func (h *MFAHandler) DisableTOTP(ctx *gin.Context) {
+	var mfaCodeRequest service.MFACodeRequest
+	if err := ctx.BindJSON(&mfaCodeRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.mfaService.DisableTOTP(ctx, mfaCodeRequest)
+	if err != nil {
+		ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *MFAHandler) RegenerateRecoveryCodes(ctx *gin.Context) {
+	var mfaCodeRequest service.MFACodeRequest
+	if err := ctx.BindJSON(&mfaCodeRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := h.mfaService.RegenerateRecoveryCodes(ctx, mfaCodeRequest)
+	if err != nil {
+		ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func (h *MFAHandler) SignIn(ctx *gin.Context) {
+	var mfaSignInRequest service.MFASignInRequest
+	if err := ctx.BindJSON(&mfaSignInRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.mfaService.CompleteChallenge(ctx, mfaSignInRequest)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if err != nil {
+		ctx.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrInvalidMFAChallenge):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnrolled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/service"
 )
 
@@ -17,6 +19,8 @@ func NewOTPHandler(otpService *service.OTPService) *OTPHandler {
 
 type OTPRequest struct {
 	PhoneNo string `json:"phone_no"`
+	Channel string `json:"channel"`
+	Resend  bool   `json:"resend"`
 }
 
 type OTPVerifyRequest struct {
@@ -33,13 +37,24 @@ func (h *OTPHandler) GenerateOTP(ctx *gin.Context) {
 		return
 	}
 
-	verificationId, err := h.otpService.GenerateOTP(ctx, otpRequest.PhoneNo)
+	delivery, err := h.otpService.GenerateOTP(ctx, otpRequest.PhoneNo, otpRequest.Channel, otpRequest.Resend)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if errors.Is(err, service.ErrUnsupportedOTPChannel) || errors.Is(err, phonenumber.ErrInvalid) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrOTPDeliveryFailed) {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"verification_id": verificationId})
+	ctx.JSON(http.StatusOK, delivery)
 }
 
 func (h *OTPHandler) VerifyOTP(ctx *gin.Context) {
@@ -49,11 +64,24 @@ func (h *OTPHandler) VerifyOTP(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.otpService.VerifyOTP(ctx, otpVerifyRequest.PhoneNo, otpVerifyRequest.VerificationId, otpVerifyRequest.OTP, otpVerifyRequest.UserID)
+	result, err := h.otpService.VerifyOTP(ctx, otpVerifyRequest.PhoneNo, otpVerifyRequest.VerificationId, otpVerifyRequest.OTP, otpVerifyRequest.UserID)
+	if errors.Is(err, service.ErrPhoneChangeRequired) || errors.Is(err, phonenumber.ErrInvalid) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"token": token})
+	respondSignIn(ctx, result)
+}
+
+// isOTPError reports whether err means the OTP given was wrong, expired or
+// used up, rather than that checking it failed.
+func isOTPError(err error) bool {
+	return errors.Is(err, service.ErrInvalidOTP) ||
+		errors.Is(err, service.ErrOTPExpired) ||
+		errors.Is(err, service.ErrOTPTooManyAttempts) ||
+		errors.Is(err, service.ErrOTPPurposeMismatch)
 }
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type PasswordHandler struct {
+	passwordService service.PasswordService
+}
+
+func NewPasswordHandler(passwordService *service.PasswordService) *PasswordHandler {
+	return &PasswordHandler{passwordService: *passwordService}
+}
+
+type ForgotPasswordRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+func (h *PasswordHandler) ForgotPassword(ctx *gin.Context) {
+	var forgotPasswordRequest ForgotPasswordRequest
+	if err := ctx.BindJSON(&forgotPasswordRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	verificationId, err := h.passwordService.ForgotPassword(ctx, forgotPasswordRequest.Phone)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if errors.Is(err, phonenumber.ErrInvalid) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrOTPDeliveryFailed) {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"verification_id": verificationId})
+}
+
+func (h *PasswordHandler) ResetPassword(ctx *gin.Context) {
+	var passwordResetRequest service.PasswordResetRequest
+	if err := ctx.BindJSON(&passwordResetRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.passwordService.ResetPassword(ctx, passwordResetRequest)
+	if isPasswordPolicyError(err) || errors.Is(err, phonenumber.ErrInvalid) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if isOTPError(err) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *PasswordHandler) ChangePassword(ctx *gin.Context) {
+	var passwordChangeRequest service.PasswordChangeRequest
+	if err := ctx.BindJSON(&passwordChangeRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.passwordService.ChangePassword(ctx, passwordChangeRequest)
+	if isPasswordPolicyError(err) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCurrentPassword) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func isPasswordPolicyError(err error) bool {
+	return errors.Is(err, service.ErrPasswordRequired) ||
+		errors.Is(err, service.ErrPasswordTooShort) ||
+		errors.Is(err, service.ErrPasswordTooCommon) ||
+		errors.Is(err, service.ErrPasswordIsPhone)
+}
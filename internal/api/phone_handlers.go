@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type PhoneChangeHandler struct {
+	phoneChangeService service.PhoneChangeService
+}
+
+func NewPhoneChangeHandler(phoneChangeService *service.PhoneChangeService) *PhoneChangeHandler {
+	return &PhoneChangeHandler{phoneChangeService: *phoneChangeService}
+}
+
+func (h *PhoneChangeHandler) StartPhoneChange(ctx *gin.Context) {
+	var phoneChangeRequest service.PhoneChangeRequest
+	if err := ctx.BindJSON(&phoneChangeRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, err := h.phoneChangeService.StartPhoneChange(ctx, phoneChangeRequest)
+	if respondThrottled(ctx, err) {
+		return
+	}
+	if err != nil {
+		ctx.JSON(phoneChangeErrorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, start)
+}
+
+func (h *PhoneChangeHandler) ConfirmPhoneChange(ctx *gin.Context) {
+	var phoneChangeConfirmRequest service.PhoneChangeConfirmRequest
+	if err := ctx.BindJSON(&phoneChangeConfirmRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.phoneChangeService.ConfirmPhoneChange(ctx, phoneChangeConfirmRequest)
+	if err != nil {
+		ctx.JSON(phoneChangeErrorStatus(err, http.StatusUnauthorized), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+// phoneChangeErrorStatus maps errors of the phone change flow to a status,
+// using fallback for the rest, such as a wrong OTP.
+func phoneChangeErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, phonenumber.ErrInvalid), errors.Is(err, service.ErrPhoneUnchanged), errors.Is(err, service.ErrOldPhoneNotConfirmed):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPhoneInUse):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrOTPDeliveryFailed):
+		return http.StatusBadGateway
+	default:
+		return fallback
+	}
+}
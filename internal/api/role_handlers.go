@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/service"
+)
+
+type RoleHandler struct {
+	roleService service.RoleService
+}
+
+func NewRoleHandler(roleService *service.RoleService) *RoleHandler {
+	return &RoleHandler{roleService: *roleService}
+}
+
+type GrantRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func (h *RoleHandler) GetRoles(ctx *gin.Context) {
+	roles, err := h.roleService.GetRoles(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, roles)
+}
+
+func (h *RoleHandler) GrantRole(ctx *gin.Context) {
+	var grantRoleRequest GrantRoleRequest
+	if err := ctx.BindJSON(&grantRoleRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.roleService.GrantRole(ctx, ctx.Param("id"), grantRoleRequest.Role)
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (h *RoleHandler) RevokeRole(ctx *gin.Context) {
+	user, err := h.roleService.RevokeRole(ctx, ctx.Param("id"), ctx.Param("role"))
+	if err != nil {
+		ctx.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRoleNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
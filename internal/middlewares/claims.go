@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tanush-128/openzo_backend/user/config"
+)
+
+// Claims is the payload of the access tokens we issue. The authenticated
+// claims are stored in the gin context under "user" by JwtMiddleware. MFA is
+// set on tokens from sessions that passed two-factor authentication.
+type Claims struct {
+	UserID      string   `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions,omitempty"`
+	Authorized  bool     `json:"authorized"`
+	MFA         bool     `json:"mfa,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasRole reports whether the token carries one of roles.
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, held := range c.Roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HasPermission reports whether the token carries permission.
+func (c *Claims) HasPermission(permission string) bool {
+	for _, held := range c.Permissions {
+		if held == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsSelfOrRole reports whether the token belongs to userID or carries one of
+// roles.
+func (c *Claims) IsSelfOrRole(userID string, roles ...string) bool {
+	return c.UserID == userID || c.HasRole(roles...)
+}
+
+// validate checks the registered claims against cfg, allowing cfg.Leeway of
+// clock skew. Expiry is mandatory; nbf and iat are checked when present.
+func (c *Claims) validate(cfg config.JWTConfig, now time.Time) error {
+	if !c.VerifyExpiresAt(now.Add(-cfg.Leeway), true) {
+		return jwt.ErrTokenExpired
+	}
+	if !c.VerifyNotBefore(now.Add(cfg.Leeway), false) {
+		return jwt.ErrTokenNotValidYet
+	}
+	if !c.VerifyIssuedAt(now.Add(cfg.Leeway), false) {
+		return jwt.ErrTokenUsedBeforeIssued
+	}
+	if cfg.Issuer != "" && !c.VerifyIssuer(cfg.Issuer, true) {
+		return jwt.ErrTokenInvalidIssuer
+	}
+	if cfg.Audience != "" && !c.VerifyAudience(cfg.Audience, true) {
+		return jwt.ErrTokenInvalidAudience
+	}
+	if c.UserID == "" {
+		return errors.New("token has no user_id")
+	}
+
+	return nil
+}
+
+// GetClaims returns the claims JwtMiddleware stored for the request.
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get("user")
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+
+	return claims, ok
+}
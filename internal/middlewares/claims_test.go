@@ -0,0 +1,173 @@
+package middlewares
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+)
+
+func TestClaimsValidate(t *testing.T) {
+	cfg := config.JWTConfig{Issuer: "openzo-user", Audience: "openzo", Leeway: 30 * time.Second}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(now.Add(d)) }
+
+	valid := func() *Claims {
+		return &Claims{
+			UserID: "user-1",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "openzo-user",
+				Audience:  jwt.ClaimStrings{"openzo"},
+				IssuedAt:  at(-time.Minute),
+				NotBefore: at(-time.Minute),
+				ExpiresAt: at(time.Minute),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Claims)
+		wantErr error
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"expired within leeway", func(c *Claims) { c.ExpiresAt = at(-20 * time.Second) }, nil},
+		{"expired beyond leeway", func(c *Claims) { c.ExpiresAt = at(-40 * time.Second) }, jwt.ErrTokenExpired},
+		{"missing exp", func(c *Claims) { c.ExpiresAt = nil }, jwt.ErrTokenExpired},
+		{"nbf within leeway", func(c *Claims) { c.NotBefore = at(20 * time.Second) }, nil},
+		{"nbf beyond leeway", func(c *Claims) { c.NotBefore = at(40 * time.Second) }, jwt.ErrTokenNotValidYet},
+		{"missing nbf", func(c *Claims) { c.NotBefore = nil }, nil},
+		{"iat in the future", func(c *Claims) { c.IssuedAt = at(time.Minute) }, jwt.ErrTokenUsedBeforeIssued},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "someone-else" }, jwt.ErrTokenInvalidIssuer},
+		{"missing issuer", func(c *Claims) { c.Issuer = "" }, jwt.ErrTokenInvalidIssuer},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }, jwt.ErrTokenInvalidAudience},
+		{"one of several audiences", func(c *Claims) { c.Audience = jwt.ClaimStrings{"other", "openzo"} }, nil},
+		{"missing audience", func(c *Claims) { c.Audience = nil }, jwt.ErrTokenInvalidAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+
+			err := claims.validate(cfg, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing user_id", func(t *testing.T) {
+		claims := valid()
+		claims.UserID = ""
+		if err := claims.validate(cfg, now); err == nil {
+			t.Error("validate() accepted a token without a user_id")
+		}
+	})
+}
+
+func TestValidateJwtTokenRejectsAlgorithmAndKeyMismatches(t *testing.T) {
+	cfg := config.JWTConfig{
+		SigningKeyID: "hs",
+		Keys:         []config.JWTKeyConfig{{ID: "hs", Secret: "a-long-enough-shared-secret"}},
+		Issuer:       "openzo-user",
+		Audience:     "openzo",
+	}
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := newES256KeySet(t, "es")
+	if err := keys.add(es.signing); err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(cfg, keys, repository.NewMemoryRevocationStore())
+
+	claims := Claims{
+		UserID: "user-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-1",
+			Issuer:    "openzo-user",
+			Audience:  jwt.ClaimStrings{"openzo"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	sign := func(method jwt.SigningMethod, kid interface{}, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256 with its kid", sign(jwt.SigningMethodHS256, "hs", []byte("a-long-enough-shared-secret")), true},
+		{"es256 with its kid", sign(jwt.SigningMethodES256, "es", es.signing.signKey), true},
+		{"missing kid", sign(jwt.SigningMethodHS256, nil, []byte("a-long-enough-shared-secret")), false},
+		{"unknown kid", sign(jwt.SigningMethodHS256, "gone", []byte("a-long-enough-shared-secret")), false},
+		{"hs384 with an hs256 kid", sign(jwt.SigningMethodHS384, "hs", []byte("a-long-enough-shared-secret")), false},
+		{"es256 signed under the hs256 kid", sign(jwt.SigningMethodES256, "hs", es.signing.signKey), false},
+		{"hs256 signed under the es256 kid", sign(jwt.SigningMethodHS256, "es", []byte("a-long-enough-shared-secret")), false},
+		{"none", sign(jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.ValidateJwtToken(tt.token)
+			if tt.ok && err != nil {
+				t.Errorf("ValidateJwtToken() = %v, want it accepted", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("ValidateJwtToken() accepted the token")
+			}
+		})
+	}
+}
+
+func TestValidateJwtTokenRejectsRevokedTokens(t *testing.T) {
+	cfg := config.JWTConfig{
+		SigningKeyID: "hs",
+		Keys:         []config.JWTKeyConfig{{ID: "hs", Secret: "a-long-enough-shared-secret"}},
+	}
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocations := repository.NewMemoryRevocationStore()
+	auth := NewAuthenticator(cfg, keys, revocations)
+
+	expiresAt := time.Now().Add(time.Minute)
+	token, err := keys.Sign(Claims{
+		UserID: "user-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-1",
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Second)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.ValidateJwtToken(token); err != nil {
+		t.Fatalf("before revocation: %v", err)
+	}
+	if err := revocations.RevokeToken("token-1", "user-1", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateJwtToken(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("after revocation: err = %v, want %v", err, ErrTokenRevoked)
+	}
+}
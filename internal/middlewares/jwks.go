@@ -0,0 +1,230 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWK is the public half of an RS256 or ES256 key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys other services need to verify our tokens
+// offline. Shared HS256 secrets are never published.
+func (k *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range k.publicKeys() {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty = "EC"
+			jwk.Crv = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+
+	return jwks
+}
+
+// NewKeySetFromJWKS builds a verification-only key set from a published JWKS
+// document, so other services can validate tokens without calling us.
+func NewKeySetFromJWKS(jwks JWKS) (*KeySet, error) {
+	keySet := &KeySet{keys: make(map[string]*SigningKey)}
+
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.signingKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := keySet.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keySet, nil
+}
+
+// RemoteKeySet verifies tokens against the JWKS document served at a URL,
+// so other services can validate our tokens offline. The document is cached
+// for ttl, and fetched again early when a token names a kid the cache
+// doesn't know, as happens right after a key rotation. Early fetches are at
+// most one per minRefresh, so tokens with made up kids can't hammer the
+// issuer.
+type RemoteKeySet struct {
+	url        string
+	ttl        time.Duration
+	minRefresh time.Duration
+	fetch      func(url string) (JWKS, error)
+
+	mu        sync.Mutex
+	keys      *KeySet
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+func NewRemoteKeySet(url string, ttl time.Duration, minRefresh time.Duration) *RemoteKeySet {
+	return &RemoteKeySet{url: url, ttl: ttl, minRefresh: minRefresh, fetch: FetchJWKS}
+}
+
+// Keyfunc resolves the verification key of a parsed token like
+// KeySet.Keyfunc, fetching the JWKS document when the cache is stale or
+// doesn't know the token's kid. If a fetch fails, the cached keys keep
+// being used.
+func (r *RemoteKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.keys == nil || now.Sub(r.fetchedAt) >= r.ttl {
+		if err := r.refresh(now); err != nil && r.keys == nil {
+			return nil, err
+		}
+	}
+
+	key, err := r.keys.Keyfunc(token)
+	if errors.Is(err, ErrUnknownKey) && now.Sub(r.triedAt) >= r.minRefresh {
+		if err := r.refresh(now); err != nil {
+			return nil, err
+		}
+		key, err = r.keys.Keyfunc(token)
+	}
+
+	return key, err
+}
+
+// Algorithms returns the algorithms a JWKS document can hold. Shared HS256
+// secrets are never published, so they are not among them.
+func (r *RemoteKeySet) Algorithms() []string {
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+}
+
+// refresh replaces the cached keys with a fresh copy of the JWKS document.
+// It must be called with r.mu held.
+func (r *RemoteKeySet) refresh(now time.Time) error {
+	r.triedAt = now
+
+	jwks, err := r.fetch(r.url)
+	if err != nil {
+		return fmt.Errorf("fetching jwks: %w", err)
+	}
+	keys, err := NewKeySetFromJWKS(jwks)
+	if err != nil {
+		return err
+	}
+
+	r.keys = keys
+	r.fetchedAt = now
+
+	return nil
+}
+
+// FetchJWKS downloads the JWKS document served at url.
+func FetchJWKS(url string) (JWKS, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(url)
+	if err != nil {
+		return JWKS{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return JWKS{}, fmt.Errorf("fetching jwks: unexpected status %s", res.Status)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return JWKS{}, err
+	}
+
+	return jwks, nil
+}
+
+func (jwk JWK) signingKey() (*SigningKey, error) {
+	if jwk.Kid == "" {
+		return nil, errors.New("jwk is missing a kid")
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", jwk.Kid, err)
+		}
+
+		return &SigningKey{
+			ID:     jwk.Kid,
+			Method: jwt.SigningMethodRS256,
+			verifyKey: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			},
+		}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("jwk %q has unsupported curve %q", jwk.Kid, jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", jwk.Kid, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", jwk.Kid, err)
+		}
+
+		public := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("jwk %q is not a point on P-256", jwk.Kid)
+		}
+
+		return &SigningKey{ID: jwk.Kid, Method: jwt.SigningMethodES256, verifyKey: public}, nil
+
+	default:
+		return nil, fmt.Errorf("jwk %q has unsupported key type %q", jwk.Kid, jwk.Kty)
+	}
+}
@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newES256KeySet(t *testing.T, id string) *KeySet {
+	t.Helper()
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &SigningKey{ID: id, Method: jwt.SigningMethodES256, signKey: private, verifyKey: &private.PublicKey}
+
+	return &KeySet{signing: key, keys: map[string]*SigningKey{id: key}}
+}
+
+func parse(keys Keys, token string) error {
+	_, err := jwt.NewParser(jwt.WithoutClaimsValidation()).ParseWithClaims(token, &Claims{}, keys.Keyfunc)
+	return err
+}
+
+func TestRemoteKeySetRefetchesOnUnknownKid(t *testing.T) {
+	old := newES256KeySet(t, "old")
+	rotated := newES256KeySet(t, "new")
+
+	published := old
+	fetches := 0
+	remote := NewRemoteKeySet("https://issuer.example/jwks.json", time.Hour, time.Minute)
+	remote.fetch = func(string) (JWKS, error) {
+		fetches++
+		return published.JWKS(), nil
+	}
+
+	oldToken, err := old.Sign(jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(remote, oldToken); err != nil {
+		t.Fatalf("token signed by the published key: %v", err)
+	}
+
+	// The issuer rotates, and a token signed by the new key arrives before
+	// the cache expires.
+	published = rotated
+	remote.triedAt = time.Now().Add(-2 * time.Minute)
+	newToken, err := rotated.Sign(jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(remote, newToken); err != nil {
+		t.Fatalf("token signed by the rotated key: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("fetches = %d, want 2", fetches)
+	}
+
+	// Unknown kids within minRefresh of the last fetch don't fetch again.
+	forged := newES256KeySet(t, "forged")
+	for i := 0; i < 3; i++ {
+		forgedToken, err := forged.Sign(jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parse(remote, forgedToken); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("forged token: err = %v, want %v", err, ErrUnknownKey)
+		}
+	}
+	if fetches != 2 {
+		t.Fatalf("fetches = %d after unknown kids, want 2", fetches)
+	}
+}
+
+func TestRemoteKeySetKeepsCachedKeysWhenFetchFails(t *testing.T) {
+	keys := newES256KeySet(t, "current")
+
+	remote := NewRemoteKeySet("https://issuer.example/jwks.json", time.Hour, time.Minute)
+	remote.fetch = func(string) (JWKS, error) { return keys.JWKS(), nil }
+
+	token, err := keys.Sign(jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(remote, token); err != nil {
+		t.Fatal(err)
+	}
+
+	remote.fetch = func(string) (JWKS, error) { return JWKS{}, errors.New("issuer is down") }
+	remote.fetchedAt = time.Now().Add(-2 * time.Hour)
+	if err := parse(remote, token); err != nil {
+		t.Fatalf("stale cache with the issuer down: %v", err)
+	}
+}
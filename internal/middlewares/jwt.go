@@ -1,11 +1,47 @@
 package middlewares
 
 import (
+	"errors"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
 )
 
-func JwtMiddleware(c *gin.Context) {
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Keys resolves the key that verifies a token. It is a KeySet in the issuing
+// service, and a RemoteKeySet where tokens are checked against a published
+// JWKS document.
+type Keys interface {
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	Algorithms() []string
+}
+
+type Authenticator struct {
+	cfg         config.JWTConfig
+	keys        Keys
+	revocations repository.RevocationStore
+	parser      *jwt.Parser
+}
+
+func NewAuthenticator(cfg config.JWTConfig, keys Keys, revocations repository.RevocationStore) *Authenticator {
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = keys.Algorithms()
+	}
+
+	return &Authenticator{
+		cfg:         cfg,
+		keys:        keys,
+		revocations: revocations,
+		parser:      jwt.NewParser(jwt.WithValidMethods(algorithms), jwt.WithoutClaimsValidation()),
+	}
+}
+
+func (a *Authenticator) JwtMiddleware(c *gin.Context) {
 	//get the token from the header
 	token := c.GetHeader("Authorization")
 	if token == "" {
@@ -15,7 +51,7 @@ func JwtMiddleware(c *gin.Context) {
 
 	}
 	//validate the token
-	claims, err := ValidateJwtToken(token)
+	claims, err := a.ValidateJwtToken(token)
 	if err != nil {
 
 		c.JSON(401, gin.H{"error": "Invalid token"})
@@ -28,16 +64,30 @@ func JwtMiddleware(c *gin.Context) {
 	c.Next()
 }
 
-func ValidateJwtToken(token string) (map[string]interface{}, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte("secret"), nil
-	})
+// ValidateJwtToken verifies the signature, algorithm and registered claims
+// of an access token and rejects it if it has been revoked.
+func (a *Authenticator) ValidateJwtToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := a.parser.ParseWithClaims(token, claims, a.keys.Keyfunc)
 	if err != nil {
 		return nil, err
 	}
-	// if claims["exp"].(float64) < float64(time.Now().Unix()) {
-	// 	return nil, err
-	// }
+
+	if err := claims.validate(a.cfg, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	revoked, err := a.revocations.IsRevoked(claims.ID, claims.UserID, issuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,237 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tanush-128/openzo_backend/user/config"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrKeyRetired = errors.New("signing key has been retired")
+	ErrNoSigner   = errors.New("key set has no signing key")
+)
+
+// SigningKey is a key identified by the kid header of the tokens it signs.
+// For HS256 both keys are the shared secret; for RS256 and ES256 signKey is
+// the private key (nil for verification-only keys) and verifyKey the public
+// key.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	RetiredAt time.Time
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds the key used to sign new tokens and every key that is still
+// accepted when verifying them, so secrets can be rotated without
+// invalidating tokens that are already out there.
+type KeySet struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+	grace   time.Duration
+}
+
+func NewKeySet(cfg config.JWTConfig) (*KeySet, error) {
+	keySet := &KeySet{
+		keys:  make(map[string]*SigningKey),
+		grace: cfg.RetiredKeyGrace,
+	}
+
+	for _, k := range cfg.Keys {
+		key, err := loadKey(k)
+		if err != nil {
+			return nil, err
+		}
+		if err := keySet.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	signing, ok := keySet.keys[cfg.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt signing key %q is not configured", cfg.SigningKeyID)
+	}
+	if !signing.RetiredAt.IsZero() {
+		return nil, fmt.Errorf("jwt signing key %q is retired", cfg.SigningKeyID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("jwt signing key %q has no private key", cfg.SigningKeyID)
+	}
+	keySet.signing = signing
+
+	return keySet, nil
+}
+
+func loadKey(k config.JWTKeyConfig) (*SigningKey, error) {
+	if k.ID == "" {
+		return nil, errors.New("jwt key is missing an id")
+	}
+
+	key := &SigningKey{ID: k.ID}
+	if k.RetiredAt != "" {
+		retiredAt, err := time.Parse(time.RFC3339, k.RetiredAt)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q has invalid retired_at: %w", k.ID, err)
+		}
+		key.RetiredAt = retiredAt
+	}
+
+	switch k.Algorithm {
+	case "", "HS256":
+		if k.Secret == "" {
+			return nil, fmt.Errorf("jwt key %q has no secret", k.ID)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey = []byte(k.Secret)
+		key.verifyKey = []byte(k.Secret)
+
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		if k.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(k.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else if k.PublicKeyFile != "" {
+			pem, err := os.ReadFile(k.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			public, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("jwt key %q needs a private or public key file", k.ID)
+		}
+
+	case "ES256":
+		key.Method = jwt.SigningMethodES256
+		if k.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(k.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			private, err := jwt.ParseECPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else if k.PublicKeyFile != "" {
+			pem, err := os.ReadFile(k.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			public, err := jwt.ParseECPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", k.ID, err)
+			}
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("jwt key %q needs a private or public key file", k.ID)
+		}
+		if key.verifyKey.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+			return nil, fmt.Errorf("jwt key %q: ES256 requires a P-256 key", k.ID)
+		}
+
+	default:
+		return nil, fmt.Errorf("jwt key %q has unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+
+	return key, nil
+}
+
+func (k *KeySet) add(key *SigningKey) error {
+	if _, ok := k.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate jwt key id %q", key.ID)
+	}
+	k.keys[key.ID] = key
+
+	return nil
+}
+
+// Sign signs the claims with the current signing key and stamps its id in
+// the kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return "", ErrNoSigner
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+
+	return token.SignedString(k.signing.signKey)
+}
+
+// Keyfunc resolves the verification key of a parsed token from its kid
+// header. The token must use the algorithm the key was configured with, and
+// tokens signed by a retired key are accepted until the grace window after
+// its retirement has passed.
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	if k.expired(key) {
+		return nil, ErrKeyRetired
+	}
+
+	return key.verifyKey, nil
+}
+
+// Algorithms returns the distinct algorithms of the keys in the set.
+func (k *KeySet) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algorithms []string
+	for _, key := range k.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algorithms = append(algorithms, alg)
+		}
+	}
+
+	return algorithms
+}
+
+func (k *KeySet) expired(key *SigningKey) bool {
+	return !key.RetiredAt.IsZero() && time.Now().After(key.RetiredAt.Add(k.grace))
+}
+
+// publicKeys returns the asymmetric keys that can still verify tokens, in no
+// particular order.
+func (k *KeySet) publicKeys() []*SigningKey {
+	var keys []*SigningKey
+	for _, key := range k.keys {
+		switch key.verifyKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			if !k.expired(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets the request through if the authenticated user has
+// one of roles. It must run after JwtMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok || !claims.HasRole(roles...) {
+			c.JSON(403, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSelfOrRole only lets the request through if the user id in the
+// route parameter param is the authenticated user's own, or if the user has
+// one of roles. It must run after JwtMiddleware.
+func RequireSelfOrRole(param string, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok || !claims.IsSelfOrRole(c.Param(param), roles...) {
+			c.JSON(403, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission only lets the request through if one of the
+// authenticated user's roles grants permission. It must run after
+// JwtMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok || !claims.HasPermission(permission) {
+			c.JSON(403, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -2,19 +2,157 @@ package models
 
 import "time"
 
+// OTP purposes. An OTP can only be verified for the purpose it was issued
+// for, so a login code can't be used to reset a password.
+const (
+	OTPPurposeLogin         = "login"
+	OTPPurposePhoneChange   = "phone_change"
+	OTPPurposePasswordReset = "password_reset"
+	OTPPurposeEmailLogin    = "email_login"
+	OTPPurposeEmailChange   = "email_change"
+	OTPPurposeMagicLink     = "magic_link"
+)
+
+// OTP delivery channels.
+const (
+	OTPChannelSMS      = "sms"
+	OTPChannelWhatsApp = "whatsapp"
+	OTPChannelVoice    = "voice"
+	OTPChannelEmail    = "email"
+)
+
+// OTP is a code sent to either Phone or Email. Channel is the channel that
+// delivered it. UserID is set for OTPs confirming a change to that user.
 type OTP struct {
 	ID        string `gorm:"primaryKey"`
 	Phone     string
+	Email     string `gorm:"size:255"`
+	UserID    string `gorm:"size:36"`
+	Channel   string `gorm:"size:16"`
 	HashedOTP string
+	Purpose   string    `gorm:"size:32;default:'login'"`
+	Attempts  int       `gorm:"default:0"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// OTPSend records one OTP sent for a rate limiting subject such as
+// "to:<phone or email>" or "ip:<address>".
+type OTPSend struct {
+	ID      uint      `gorm:"primaryKey"`
+	Subject string    `gorm:"size:80;index:idx_otp_sends_subject_sent_at"`
+	SentAt  time.Time `gorm:"index:idx_otp_sends_subject_sent_at;index"`
+}
+
+// RefreshToken is a single-use token that can be exchanged for a new access
+// token. Every token issued by rotating a refresh token shares the FamilyID of
+// the one issued at sign in, so reuse of a rotated token can revoke them all.
+// MFA records whether that sign in passed two-factor authentication.
+type RefreshToken struct {
+	ID         string `gorm:"primaryKey"`
+	UserID     string `gorm:"size:36;index"`
+	FamilyID   string `gorm:"size:36;index"`
+	TokenHash  string `gorm:"size:64;uniqueIndex"`
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	ReplacedBy string `gorm:"size:36"`
+	MFA        bool
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// RevokedToken blocks a single access token, identified by its jti, until
+// it would have expired anyway.
+type RevokedToken struct {
+	JTI       string `gorm:"primaryKey;size:36"`
+	UserID    string `gorm:"size:36;index"`
+	ExpiresAt time.Time
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
 
+// UserRevocation blocks every access token issued to a user up to and
+// including RevokedBefore. Both it and the iat claim have millisecond
+// precision, so tokens issued after revoking stay valid.
+type UserRevocation struct {
+	UserID        string `gorm:"primaryKey;size:36"`
+	RevokedBefore time.Time
+}
+
+const (
+	RoleUser            = "USER"
+	RoleAdmin           = "ADMIN"
+	RoleStoreOwner      = "STORE_OWNER"
+	RoleDeliveryPartner = "DELIVERY_PARTNER"
+	RoleSupport         = "SUPPORT"
+)
+
+// Role is a named set of permission strings such as "address:write". Users
+// can hold several roles through the user_roles join table.
+type Role struct {
+	Name        string   `gorm:"primaryKey;size:32" json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `gorm:"serializer:json" json:"permissions"`
+}
+
+// DefaultRoles are created at startup if they don't exist yet.
+var DefaultRoles = []Role{
+	{
+		Name:        RoleUser,
+		Description: "Customer",
+		Permissions: []string{"profile:read", "profile:write", "address:read", "address:write"},
+	},
+	{
+		Name:        RoleStoreOwner,
+		Description: "Merchant managing one or more stores",
+		Permissions: []string{"profile:read", "profile:write", "store:manage", "orders:manage"},
+	},
+	{
+		Name:        RoleDeliveryPartner,
+		Description: "Rider delivering orders",
+		Permissions: []string{"profile:read", "profile:write", "deliveries:read", "deliveries:update"},
+	},
+	{
+		Name:        RoleSupport,
+		Description: "Support agent",
+		Permissions: []string{"users:read", "address:read", "orders:read"},
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Platform administrator",
+		Permissions: []string{"users:read", "users:write", "address:read", "address:write", "orders:read", "orders:manage", "roles:manage"},
+	},
+}
+
+// PhoneChange records a change of a user's phone number.
+type PhoneChange struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"index;size:36" json:"user_id"`
+	OldPhone  string    `gorm:"size:16" json:"old_phone"`
+	NewPhone  string    `gorm:"size:16" json:"new_phone"`
+	IP        string    `gorm:"size:45" json:"ip"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Sign-in throttle scopes.
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+)
+
+// LoginThrottle counts failed password sign ins for a user id or a client IP.
+type LoginThrottle struct {
+	Scope         string     `gorm:"primaryKey;size:16" json:"scope"`
+	Subject       string     `gorm:"primaryKey;size:64" json:"subject"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
 type User struct {
 	ID                string  `gorm:"primaryKey" json:"id"`
-	Email             *string `json:"email,omitempty"`
+	Email             *string `json:"email,omitempty" gorm:"size:255"`
 	Name              *string `json:"name,omitempty"`
-	Password          *string `json:"password,omitempty"`
-	Phone             string  `json:"phone" gorm:"size:15"`
+	Password          *string `json:"-"`
+	Phone             string  `json:"phone" gorm:"size:16"`
 	Latitude          *string `json:"latitude,omitempty"`
 	Longitude         *string `json:"longitude,omitempty"`
 	Address           *string `json:"address,omitempty"`
@@ -24,9 +162,41 @@ type User struct {
 	Country           *string `json:"country,omitempty"`
 	NotificationToken *string `json:"notification_token,omitempty"`
 	IsVerified        bool    `json:"is_verified"`
+	EmailVerified     bool    `json:"email_verified"`
+	PendingEmail      *string `json:"pending_email,omitempty" gorm:"size:255"`
 	CreatedAt         time.Time
-	Role              string `json:"role" gorm:"default:'USER'"`
-	DefaultAddress    string `json:"default_address"`
+	// Role is the legacy single role column. BackfillUserRoles moves it to
+	// Roles and clears it; it is no longer read or client settable.
+	Role           string `json:"-"`
+	DefaultAddress string `json:"default_address"`
+	Roles          []Role `json:"roles,omitempty" gorm:"many2many:user_roles;"`
+}
+
+// RoleNames returns the names of the user's roles, which must have been
+// loaded. A user whose roles were all revoked has none.
+func (u User) RoleNames() []string {
+	names := make([]string, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		names = append(names, role.Name)
+	}
+
+	return names
+}
+
+// PermissionNames returns the union of the permissions of the user's roles.
+func (u User) PermissionNames() []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range u.Roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	return permissions
 }
 
 type Address struct {
@@ -54,3 +224,35 @@ type Customer struct {
 	UserDataId string `json:"user_data_id" gorm:"size:36"`
 	SaleId     string `json:"sale_id" gorm:"size:36"`
 }
+
+// TOTPSecret is a user's authenticator app secret. Two-factor sign in is only
+// required once the user has confirmed it with a code. LastUsedStep is the
+// time step of the last accepted code, so a code can't be replayed.
+type TOTPSecret struct {
+	UserID       string `gorm:"primaryKey;size:36"`
+	Secret       string `gorm:"size:64"`
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+// RecoveryCode is a single-use code that can replace a TOTP code, stored as
+// a SHA-256 hash.
+type RecoveryCode struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    string `gorm:"size:36;index"`
+	CodeHash  string `gorm:"size:64;uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// MFAChallenge is handed out by password sign in for users with two-factor
+// authentication and exchanged for tokens once a code is supplied.
+type MFAChallenge struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    string `gorm:"size:36;index"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
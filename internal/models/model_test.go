@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserJSONLeavesOutPassword(t *testing.T) {
+	hash := "$2a$10$abcdefghijklmnopqrstuv"
+	user := User{ID: "user-1", Phone: "+919876543210", Password: &hash}
+
+	body, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "password") || strings.Contains(string(body), hash) {
+		t.Errorf("user JSON contains the password hash: %s", body)
+	}
+
+	var decoded User
+	if err := json.Unmarshal([]byte(`{"id":"user-1","password":"chosen-by-client"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Password != nil {
+		t.Errorf("password was read from JSON: %q", *decoded.Password)
+	}
+}
@@ -0,0 +1,141 @@
+// Package phonenumber normalizes phone numbers to E.164, the form they are
+// stored and compared in, so that 9876543210, 09876543210 and
+// +91 98765 43210 are the same number.
+package phonenumber
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid phone number")
+
+// region describes how numbers are written within a country: its calling
+// code, the trunk prefix dialled before national numbers and the lengths of
+// its national numbers.
+type region struct {
+	callingCode string
+	trunkPrefix string
+	lengths     []int
+}
+
+var regions = map[string]region{
+	"IN": {callingCode: "91", trunkPrefix: "0", lengths: []int{10}},
+	"US": {callingCode: "1", trunkPrefix: "1", lengths: []int{10}},
+	"CA": {callingCode: "1", trunkPrefix: "1", lengths: []int{10}},
+	"GB": {callingCode: "44", trunkPrefix: "0", lengths: []int{10}},
+	"AE": {callingCode: "971", trunkPrefix: "0", lengths: []int{8, 9}},
+	"SG": {callingCode: "65", lengths: []int{8}},
+	"AU": {callingCode: "61", trunkPrefix: "0", lengths: []int{9}},
+}
+
+// KnownRegion reports whether Normalize can read national numbers of the
+// region with the given ISO 3166 code, such as "IN".
+func KnownRegion(code string) bool {
+	_, ok := regions[strings.ToUpper(code)]
+	return ok
+}
+
+// Normalize returns raw in E.164 form, such as +919876543210. Numbers
+// written without a + or 00 international prefix are read as national
+// numbers of defaultRegion. Spaces, dashes, dots and brackets are ignored.
+func Normalize(raw string, defaultRegion string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	international := false
+	switch {
+	case strings.HasPrefix(raw, "+"):
+		international = true
+		raw = raw[1:]
+	case strings.HasPrefix(raw, "00"):
+		international = true
+		raw = raw[2:]
+	}
+
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalid
+		}
+	}
+	number := digits.String()
+
+	if !international {
+		region, ok := regions[strings.ToUpper(defaultRegion)]
+		if !ok {
+			return "", ErrInvalid
+		}
+
+		national, ok := region.national(number)
+		if !ok {
+			return "", ErrInvalid
+		}
+		number = region.callingCode + national
+	}
+
+	normalized := "+" + number
+	if !IsE164(normalized) || !validLength(number) {
+		return "", ErrInvalid
+	}
+
+	return normalized, nil
+}
+
+// IsE164 reports whether s is a phone number in E.164 form.
+func IsE164(s string) bool {
+	if len(s) < 9 || len(s) > 16 || s[0] != '+' || s[1] == '0' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// national strips any calling code or trunk prefix from a number written
+// without an international prefix, and returns the national number if it
+// has a valid length.
+func (r region) national(number string) (string, bool) {
+	if rest := strings.TrimPrefix(number, r.callingCode); rest != number && r.validLength(rest) {
+		return rest, true
+	}
+	if r.trunkPrefix != "" {
+		if rest := strings.TrimPrefix(number, r.trunkPrefix); rest != number && r.validLength(rest) {
+			return rest, true
+		}
+	}
+	if r.validLength(number) {
+		return number, true
+	}
+
+	return "", false
+}
+
+func (r region) validLength(national string) bool {
+	for _, length := range r.lengths {
+		if len(national) == length {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validLength checks the length of an international number against its
+// region, when the calling code is a known one.
+func validLength(number string) bool {
+	for _, region := range regions {
+		if rest := strings.TrimPrefix(number, region.callingCode); rest != number {
+			return region.validLength(rest)
+		}
+	}
+
+	return true
+}
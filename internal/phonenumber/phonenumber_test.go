@@ -0,0 +1,84 @@
+package phonenumber
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw    string
+		region string
+		want   string
+	}{
+		{"9876543210", "IN", "+919876543210"},
+		{"09876543210", "IN", "+919876543210"},
+		{"919876543210", "IN", "+919876543210"},
+		{"+91 98765 43210", "IN", "+919876543210"},
+		{"0091-98765-43210", "IN", "+919876543210"},
+		{"  +91 (98765) 43210 ", "US", "+919876543210"},
+		{"(415) 555-2671", "US", "+14155552671"},
+		{"1 415 555 2671", "US", "+14155552671"},
+		{"020 7946 0018", "gb", "+442079460018"},
+		{"050 123 4567", "AE", "+971501234567"},
+		{"6123 4567", "SG", "+6561234567"},
+		{"+33 1 23 45 67 89", "IN", "+33123456789"},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.region)
+		if err != nil {
+			t.Errorf("Normalize(%q, %q): %v", tt.raw, tt.region, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, want %q", tt.raw, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		region string
+	}{
+		{"empty", "", "IN"},
+		{"letters", "98765abcde", "IN"},
+		{"too short", "98765", "IN"},
+		{"too long", "98765432101", "IN"},
+		{"wrong length for calling code", "+91 98765 4321", "IN"},
+		{"unknown region", "9876543210", "ZZ"},
+		{"leading zero calling code", "+0123456789", "IN"},
+		{"plus in the middle", "98765+43210", "IN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Normalize(tt.raw, tt.region); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Normalize(%q, %q) = %q, %v, want %v", tt.raw, tt.region, got, err, ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestIsE164(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"+919876543210", true},
+		{"+14155552671", true},
+		{"919876543210", false},
+		{"+0919876543210", false},
+		{"+91987", false},
+		{"+9198765432101234", false},
+		{"+91 9876543210", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsE164(tt.s); got != tt.want {
+			t.Errorf("IsE164(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
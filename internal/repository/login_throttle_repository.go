@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginThrottleRepository interface {
+	GetThrottle(scope string, subject string) (models.LoginThrottle, error)
+	UpdateThrottle(scope string, subject string, update func(throttle *models.LoginThrottle)) (models.LoginThrottle, error)
+	DeleteThrottle(scope string, subject string) error
+	GetActiveThrottles(since time.Time) ([]models.LoginThrottle, error)
+}
+
+type loginThrottleRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) LoginThrottleRepository {
+
+	return &loginThrottleRepository{db: db}
+}
+
+func (r *loginThrottleRepository) GetThrottle(scope string, subject string) (models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	tx := r.db.Where("scope = ? AND subject = ?", scope, subject).First(&throttle)
+	if tx.Error != nil {
+		return models.LoginThrottle{}, tx.Error
+	}
+
+	return throttle, nil
+}
+
+// UpdateThrottle loads the throttle row, creating it if needed, applies
+// update and saves it, holding a row lock so concurrent failures aren't lost.
+func (r *loginThrottleRepository) UpdateThrottle(scope string, subject string, update func(throttle *models.LoginThrottle)) (models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("scope = ? AND subject = ?", scope, subject).
+			First(&throttle).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			throttle = models.LoginThrottle{Scope: scope, Subject: subject}
+		} else if err != nil {
+			return err
+		}
+
+		update(&throttle)
+
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+
+	return throttle, nil
+}
+
+func (r *loginThrottleRepository) DeleteThrottle(scope string, subject string) error {
+	tx := r.db.Where("scope = ? AND subject = ?", scope, subject).Delete(&models.LoginThrottle{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// GetActiveThrottles returns throttles with a failure since the given time
+// or a lockout that hasn't expired yet.
+func (r *loginThrottleRepository) GetActiveThrottles(since time.Time) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	tx := r.db.Where("last_failure_at >= ? OR locked_until >= ?", since, time.Now()).
+		Order("last_failure_at DESC").
+		Find(&throttles)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return throttles, nil
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTOTPStepUsed         = errors.New("TOTP code has already been used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found or already used")
+)
+
+type MFARepository interface {
+	GetTOTPSecret(userID string) (models.TOTPSecret, error)
+	SaveTOTPSecret(secret models.TOTPSecret) error
+	ConfirmTOTPSecret(userID string, step int64, recoveryCodes []models.RecoveryCode) error
+	UseTOTPStep(userID string, step int64) error
+	DeleteTOTPSecret(userID string) error
+
+	ReplaceRecoveryCodes(userID string, recoveryCodes []models.RecoveryCode) error
+	UseRecoveryCode(userID string, hash string) error
+
+	CreateChallenge(challenge models.MFAChallenge) (models.MFAChallenge, error)
+	GetChallengeByHash(hash string) (models.MFAChallenge, error)
+	IncrementChallengeAttempts(id string) error
+	DeleteChallenge(id string) error
+	DeleteExpiredChallenges(before time.Time, limit int) (int64, error)
+}
+
+type mfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+
+	return &mfaRepository{db: db}
+}
+
+func (r *mfaRepository) GetTOTPSecret(userID string) (models.TOTPSecret, error) {
+	var secret models.TOTPSecret
+	tx := r.db.Where("user_id = ?", userID).First(&secret)
+	if tx.Error != nil {
+		return models.TOTPSecret{}, tx.Error
+	}
+
+	return secret, nil
+}
+
+func (r *mfaRepository) SaveTOTPSecret(secret models.TOTPSecret) error {
+	tx := r.db.Save(&secret)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// ConfirmTOTPSecret enables the user's secret, marking step as used, and
+// stores their first set of recovery codes.
+func (r *mfaRepository) ConfirmTOTPSecret(userID string, step int64, recoveryCodes []models.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.TOTPSecret{}).
+			Where("user_id = ? AND last_used_step < ?", userID, step).
+			Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTOTPStepUsed
+		}
+
+		return replaceRecoveryCodes(tx, userID, recoveryCodes)
+	})
+}
+
+// UseTOTPStep records step as the last accepted code. It fails with
+// ErrTOTPStepUsed if that step or a later one was accepted before.
+func (r *mfaRepository) UseTOTPStep(userID string, step int64) error {
+	tx := r.db.Model(&models.TOTPSecret{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrTOTPStepUsed
+	}
+
+	return nil
+}
+
+// DeleteTOTPSecret removes the user's secret along with their recovery codes.
+func (r *mfaRepository) DeleteTOTPSecret(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPSecret{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(userID string, recoveryCodes []models.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, recoveryCodes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID string, recoveryCodes []models.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	for i := range recoveryCodes {
+		recoveryCodes[i].ID = uuid.New().String()
+		recoveryCodes[i].UserID = userID
+	}
+	if len(recoveryCodes) == 0 {
+		return nil
+	}
+
+	return tx.Create(&recoveryCodes).Error
+}
+
+// UseRecoveryCode marks the user's unused recovery code with the given hash
+// as used, failing with ErrRecoveryCodeNotFound if there is none.
+func (r *mfaRepository) UseRecoveryCode(userID string, hash string) error {
+	tx := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+func (r *mfaRepository) CreateChallenge(challenge models.MFAChallenge) (models.MFAChallenge, error) {
+	challenge.ID = uuid.New().String()
+
+	tx := r.db.Create(&challenge)
+	if tx.Error != nil {
+		return models.MFAChallenge{}, tx.Error
+	}
+
+	return challenge, nil
+}
+
+func (r *mfaRepository) GetChallengeByHash(hash string) (models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	tx := r.db.Where("token_hash = ?", hash).First(&challenge)
+	if tx.Error != nil {
+		return models.MFAChallenge{}, tx.Error
+	}
+
+	return challenge, nil
+}
+
+func (r *mfaRepository) IncrementChallengeAttempts(id string) error {
+	tx := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (r *mfaRepository) DeleteChallenge(id string) error {
+	tx := r.db.Where("id = ?", id).Delete(&models.MFAChallenge{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// DeleteExpiredChallenges deletes up to limit challenges that expired before
+// the given time and returns how many were deleted.
+func (r *mfaRepository) DeleteExpiredChallenges(before time.Time, limit int) (int64, error) {
+	var ids []string
+	tx := r.db.Model(&models.MFAChallenge{}).
+		Where("expires_at < ?", before).
+		Limit(limit).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx = r.db.Where("id IN ?", ids).Delete(&models.MFAChallenge{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
 	"gorm.io/gorm"
@@ -12,10 +15,19 @@ import (
 // 	CreatedAt time.Time `gorm:"autoCreateTime"`
 // }
 
+var (
+	ErrOTPAttemptsExhausted = errors.New("no OTP verification attempts left")
+	ErrOTPUsed              = errors.New("OTP has already been used")
+)
+
 type OTPRepository interface {
 	CreateOTP(otp models.OTP) (models.OTP, error)
 	GetOTPByID(id string) (models.OTP, error)
+	GetLatestOTP(phone string, purpose string) (models.OTP, error)
+	UseOTPAttempt(id string, maxAttempts int) error
+	UseOTP(id string) error
 	DeleteOTP(id string) error
+	DeleteExpiredOTPs(before time.Time, legacyBefore time.Time, limit int) (int64, error)
 }
 
 type otpRepository struct {
@@ -28,6 +40,10 @@ func NewOTPRepository(db *gorm.DB) OTPRepository {
 }
 
 func (r *otpRepository) CreateOTP(otp models.OTP) (models.OTP, error) {
+	if err := checkPhone(otp.Phone); err != nil {
+		return models.OTP{}, err
+	}
+
 	otp.ID = uuid.New().String()
 	tx := r.db.Create(&otp)
 	if tx.Error != nil {
@@ -47,6 +63,47 @@ func (r *otpRepository) GetOTPByID(id string) (models.OTP, error) {
 	return otp, nil
 }
 
+// GetLatestOTP returns the most recent OTP sent to phone for purpose.
+func (r *otpRepository) GetLatestOTP(phone string, purpose string) (models.OTP, error) {
+	var otp models.OTP
+	tx := r.db.Where("phone = ? AND purpose = ?", phone, purpose).Order("created_at DESC").First(&otp)
+	if tx.Error != nil {
+		return models.OTP{}, tx.Error
+	}
+
+	return otp, nil
+}
+
+// UseOTPAttempt counts a verification attempt against the OTP. It fails with
+// ErrOTPAttemptsExhausted, without counting, once maxAttempts have been used.
+func (r *otpRepository) UseOTPAttempt(id string, maxAttempts int) error {
+	tx := r.db.Model(&models.OTP{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrOTPAttemptsExhausted
+	}
+
+	return nil
+}
+
+// UseOTP deletes the OTP once it has been verified. It fails with ErrOTPUsed
+// if the OTP is already gone, for example because a concurrent verification
+// used it first.
+func (r *otpRepository) UseOTP(id string) error {
+	tx := r.db.Where("id = ?", id).Delete(&models.OTP{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrOTPUsed
+	}
+
+	return nil
+}
 
 func (r *otpRepository) DeleteOTP(id string) error {
 	tx := r.db.Where("id = ?", id).Delete(&models.OTP{})
@@ -56,3 +113,28 @@ func (r *otpRepository) DeleteOTP(id string) error {
 
 	return nil
 }
+
+// DeleteExpiredOTPs deletes up to limit OTPs that expired before the given
+// time and returns how many were deleted. OTPs stored without an expiry, by
+// versions that didn't record one, are deleted once they were created
+// before legacyBefore.
+func (r *otpRepository) DeleteExpiredOTPs(before time.Time, legacyBefore time.Time, limit int) (int64, error) {
+	var ids []string
+	tx := r.db.Model(&models.OTP{}).
+		Where("(expires_at >= created_at AND expires_at < ?) OR ((expires_at IS NULL OR expires_at < created_at) AND created_at < ?)", before, legacyBefore).
+		Limit(limit).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx = r.db.Where("id IN ?", ids).Delete(&models.OTP{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
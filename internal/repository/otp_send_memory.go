@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryOTPSendStore keeps OTP sends in process memory. Limits are per
+// replica and reset on restart, so it is meant for tests and local
+// development.
+type memoryOTPSendStore struct {
+	mu     sync.Mutex
+	nextID uint
+	sends  map[string][]memoryOTPSend
+}
+
+type memoryOTPSend struct {
+	id uint
+	at time.Time
+}
+
+func NewMemoryOTPSendStore() OTPSendStore {
+
+	return &memoryOTPSendStore{sends: make(map[string][]memoryOTPSend)}
+}
+
+func (r *memoryOTPSendStore) RecordSend(key string, at time.Time) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	r.sends[key] = append(r.sends[key], memoryOTPSend{id: r.nextID, at: at})
+
+	return r.nextID, nil
+}
+
+func (r *memoryOTPSendStore) GetSends(key string, since time.Time, recordedBefore uint) ([]time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var times []time.Time
+	for _, send := range r.sends[key] {
+		if send.id < recordedBefore && !send.at.Before(since) {
+			times = append(times, send.at)
+		}
+	}
+
+	return times, nil
+}
+
+func (r *memoryOTPSendStore) DeleteSend(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, sends := range r.sends {
+		for i, send := range sends {
+			if send.id == id {
+				r.sends[key] = append(sends[:i], sends[i+1:]...)
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryOTPSendStore) DeleteSendsBefore(before time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, sends := range r.sends {
+		kept := sends[:0]
+		for _, send := range sends {
+			if deleted < int64(limit) && send.at.Before(before) {
+				deleted++
+				continue
+			}
+			kept = append(kept, send)
+		}
+		if len(kept) == 0 {
+			delete(r.sends, key)
+		} else {
+			r.sends[key] = kept
+		}
+	}
+
+	return deleted, nil
+}
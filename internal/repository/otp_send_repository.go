@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+)
+
+// OTPSendStore records when OTPs were sent for a key, such as a recipient or
+// client IP, so sends can be rate limited. Sends are recorded before the
+// limits are checked, and taken back with DeleteSend if one was reached.
+type OTPSendStore interface {
+	RecordSend(key string, at time.Time) (uint, error)
+	GetSends(key string, since time.Time, recordedBefore uint) ([]time.Time, error)
+	DeleteSend(id uint) error
+	DeleteSendsBefore(before time.Time, limit int) (int64, error)
+}
+
+type otpSendStore struct {
+	db *gorm.DB
+}
+
+func NewOTPSendStore(db *gorm.DB) OTPSendStore {
+
+	return &otpSendStore{db: db}
+}
+
+// RecordSend stores a send for key and returns its id.
+func (r *otpSendStore) RecordSend(key string, at time.Time) (uint, error) {
+	send := models.OTPSend{Subject: key, SentAt: at}
+	tx := r.db.Create(&send)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return send.ID, nil
+}
+
+// GetSends returns the times of the sends for key since the given time that
+// were recorded before the send with id recordedBefore, oldest first.
+func (r *otpSendStore) GetSends(key string, since time.Time, recordedBefore uint) ([]time.Time, error) {
+	var sends []models.OTPSend
+	tx := r.db.Where("subject = ? AND sent_at >= ? AND id < ?", key, since, recordedBefore).Order("sent_at").Find(&sends)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	times := make([]time.Time, 0, len(sends))
+	for _, send := range sends {
+		times = append(times, send.SentAt)
+	}
+
+	return times, nil
+}
+
+func (r *otpSendStore) DeleteSend(id uint) error {
+	tx := r.db.Delete(&models.OTPSend{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// DeleteSendsBefore deletes up to limit sends made before the given time and
+// returns how many were deleted.
+func (r *otpSendStore) DeleteSendsBefore(before time.Time, limit int) (int64, error) {
+	var ids []uint
+	tx := r.db.Model(&models.OTPSend{}).
+		Where("sent_at < ?", before).
+		Limit(limit).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx = r.db.Where("id IN ?", ids).Delete(&models.OTPSend{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
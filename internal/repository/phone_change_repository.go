@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPhoneInUse = errors.New("phone number belongs to another user")
+
+type PhoneChangeRepository interface {
+	ChangePhone(userID string, newPhone string, ip string) (models.PhoneChange, error)
+	GetPhoneChanges(userID string) ([]models.PhoneChange, error)
+}
+
+type phoneChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewPhoneChangeRepository(db *gorm.DB) PhoneChangeRepository {
+
+	return &phoneChangeRepository{db: db}
+}
+
+// ChangePhone sets the user's phone number, marks it verified and records
+// the change, in one transaction. It fails with ErrPhoneInUse if another
+// user already has the number, which the unique phone index enforces.
+func (r *phoneChangeRepository) ChangePhone(userID string, newPhone string, ip string) (models.PhoneChange, error) {
+	if !phonenumber.IsE164(newPhone) {
+		return models.PhoneChange{}, phonenumber.ErrInvalid
+	}
+
+	var change models.PhoneChange
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"phone": newPhone, "is_verified": true}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrPhoneInUse
+		}
+		if err != nil {
+			return err
+		}
+
+		change = models.PhoneChange{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			OldPhone:  user.Phone,
+			NewPhone:  newPhone,
+			IP:        ip,
+			ChangedAt: time.Now(),
+		}
+
+		return tx.Create(&change).Error
+	})
+	if err != nil {
+		return models.PhoneChange{}, err
+	}
+
+	return change, nil
+}
+
+func (r *phoneChangeRepository) GetPhoneChanges(userID string) ([]models.PhoneChange, error) {
+	var changes []models.PhoneChange
+	tx := r.db.Where("user_id = ?", userID).Order("changed_at DESC").Find(&changes)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return changes, nil
+}
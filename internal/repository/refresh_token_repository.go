@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrRefreshTokenUsed = errors.New("refresh token has already been used")
+
+type RefreshTokenRepository interface {
+	CreateRefreshToken(token models.RefreshToken) (models.RefreshToken, error)
+	GetRefreshTokenByHash(hash string) (models.RefreshToken, error)
+	RotateRefreshToken(old models.RefreshToken, next models.RefreshToken) (models.RefreshToken, error)
+	RevokeFamily(familyID string) error
+	RevokeUserTokens(userID string) error
+	DeleteExpiredRefreshTokens(before time.Time, limit int) (int64, error)
+}
+
+type refreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
+
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) CreateRefreshToken(token models.RefreshToken) (models.RefreshToken, error) {
+	token.ID = uuid.New().String()
+	if token.FamilyID == "" {
+		token.FamilyID = token.ID
+	}
+
+	tx := r.db.Create(&token)
+	if tx.Error != nil {
+		return models.RefreshToken{}, tx.Error
+	}
+
+	return token, nil
+}
+
+func (r *refreshTokenRepository) GetRefreshTokenByHash(hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	tx := r.db.Where("token_hash = ?", hash).First(&token)
+	if tx.Error != nil {
+		return models.RefreshToken{}, tx.Error
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken revokes old and stores next in its family. If old was
+// already revoked, for example by a concurrent refresh, nothing is stored and
+// ErrRefreshTokenUsed is returned.
+func (r *refreshTokenRepository) RotateRefreshToken(old models.RefreshToken, next models.RefreshToken) (models.RefreshToken, error) {
+	next.ID = uuid.New().String()
+	next.FamilyID = old.FamilyID
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.ID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenUsed
+		}
+
+		return tx.Create(&next).Error
+	})
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	return next, nil
+}
+
+func (r *refreshTokenRepository) RevokeFamily(familyID string) error {
+	tx := r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (r *refreshTokenRepository) RevokeUserTokens(userID string) error {
+	tx := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// DeleteExpiredRefreshTokens deletes up to limit refresh tokens from families
+// whose every token expired before the given time and returns how many were
+// deleted. Families with a live token are kept whole, so replaying one of
+// their rotated tokens is still detected as reuse.
+func (r *refreshTokenRepository) DeleteExpiredRefreshTokens(before time.Time, limit int) (int64, error) {
+	live := r.db.Model(&models.RefreshToken{}).
+		Select("family_id").
+		Where("expires_at >= ?", before)
+
+	var ids []string
+	tx := r.db.Model(&models.RefreshToken{}).
+		Where("expires_at < ? AND family_id NOT IN (?)", before, live).
+		Limit(limit).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx = r.db.Where("id IN ?", ids).Delete(&models.RefreshToken{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
@@ -2,17 +2,23 @@ package repository
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrEmailInUse = errors.New("email belongs to another user")
+
 type UserRepository interface {
 	CreateUser(user models.User) (models.User, error)
 	GetUserByID(id string) (models.User, error)
 	GetUserByEmail(email string) (models.User, error)
 	GetUserByMobile(mobile string) (models.User, error)
+	GetUsersByIDs(ids []string) ([]models.User, error)
 	UpdateUser(user models.User) (models.User, error)
 	// Add more methods for other user operations (GetUserByEmail, UpdateUser, etc.)
 
@@ -28,6 +34,10 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 }
 
 func (r *userRepository) CreateUser(user models.User) (models.User, error) {
+	if err := checkPhone(user.Phone); err != nil {
+		return models.User{}, err
+	}
+
 	var user2 models.User
 	r.db.Find(
 		&user2, "phone = ?", user.Phone,
@@ -37,8 +47,14 @@ func (r *userRepository) CreateUser(user models.User) (models.User, error) {
 	}
 
 	user.ID = uuid.New().String()
+	user.Email = normalizeEmail(user.Email)
+	user.PendingEmail = normalizeEmail(user.PendingEmail)
+
+	// New users only get the user role; others are granted by an admin.
+	user.Role = ""
+	user.Roles = []models.Role{{Name: models.RoleUser}}
 
-	tx := r.db.Create(&user)
+	tx := r.db.Omit("Roles.*").Create(&user)
 
 	if tx.Error != nil {
 		return models.User{}, tx.Error
@@ -49,7 +65,7 @@ func (r *userRepository) CreateUser(user models.User) (models.User, error) {
 
 func (r *userRepository) GetUserByID(id string) (models.User, error) {
 	var user models.User
-	tx := r.db.Where("id = ?", id).First(&user)
+	tx := r.db.Preload("Roles").Where("id = ?", id).First(&user)
 	if tx.Error != nil {
 		return models.User{}, tx.Error
 	}
@@ -57,9 +73,10 @@ func (r *userRepository) GetUserByID(id string) (models.User, error) {
 	return user, nil
 }
 
+// GetUserByEmail looks a user up by email, ignoring case.
 func (r *userRepository) GetUserByEmail(email string) (models.User, error) {
 	var user models.User
-	tx := r.db.Where("email = ?", email).First(&user)
+	tx := r.db.Preload("Roles").Where("email = ?", strings.ToLower(strings.TrimSpace(email))).First(&user)
 	if tx.Error != nil {
 		return models.User{}, tx.Error
 	}
@@ -67,9 +84,15 @@ func (r *userRepository) GetUserByEmail(email string) (models.User, error) {
 	return user, nil
 }
 
+// GetUserByMobile looks a user up by phone number, which must be in E.164
+// form.
 func (r *userRepository) GetUserByMobile(mobile string) (models.User, error) {
+	if !phonenumber.IsE164(mobile) {
+		return models.User{}, phonenumber.ErrInvalid
+	}
+
 	var user models.User
-	tx := r.db.Where("phone = ?", mobile).First(&user)
+	tx := r.db.Preload("Roles").Where("phone = ?", mobile).First(&user)
 	if tx.Error != nil {
 		return models.User{}, tx.Error
 	}
@@ -77,8 +100,36 @@ func (r *userRepository) GetUserByMobile(mobile string) (models.User, error) {
 	return user, nil
 }
 
+// GetUsersByIDs returns the users with the given ids in one query. Ids
+// without a user are skipped, so fewer users than ids may be returned.
+func (r *userRepository) GetUsersByIDs(ids []string) ([]models.User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	tx := r.db.Preload("Roles").Where("id IN ?", ids).Find(&users)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return users, nil
+}
+
 func (r *userRepository) UpdateUser(user models.User) (models.User, error) {
-	tx := r.db.Save(&user)
+	if err := checkPhone(user.Phone); err != nil {
+		return models.User{}, err
+	}
+
+	user.Email = normalizeEmail(user.Email)
+	user.PendingEmail = normalizeEmail(user.PendingEmail)
+
+	tx := r.db.Omit(clause.Associations).Save(&user)
+	// Phone numbers only change through ChangePhone, so the unique key
+	// clashing here is the email's.
+	if errors.Is(tx.Error, gorm.ErrDuplicatedKey) {
+		return models.User{}, ErrEmailInUse
+	}
 	if tx.Error != nil {
 		return models.User{}, tx.Error
 	}
@@ -86,4 +137,29 @@ func (r *userRepository) UpdateUser(user models.User) (models.User, error) {
 	return user, nil
 }
 
+// normalizeEmail returns email in lower case, so the unique email index
+// ignores case, or nil if it is empty.
+func normalizeEmail(email *string) *string {
+	if email == nil {
+		return nil
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(*email))
+	if normalized == "" {
+		return nil
+	}
+
+	return &normalized
+}
+
+// checkPhone refuses to store a phone number that isn't in E.164 form, so
+// numbers can be compared as strings. Users without a phone are allowed.
+func checkPhone(phone string) error {
+	if phone != "" && !phonenumber.IsE164(phone) {
+		return phonenumber.ErrInvalid
+	}
+
+	return nil
+}
+
 // Implement other repository methods (GetUserByID, GetUserByEmail, UpdateUser, etc.) with proper error handling
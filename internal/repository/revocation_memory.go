@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryRevocationStore keeps revocations in process memory. It is meant for
+// tests and local development; revocations are lost on restart and are not
+// shared between replicas.
+type memoryRevocationStore struct {
+	mu      sync.RWMutex
+	tokens  map[string]time.Time
+	revoked map[string]time.Time
+}
+
+func NewMemoryRevocationStore() RevocationStore {
+
+	return &memoryRevocationStore{
+		tokens:  make(map[string]time.Time),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (r *memoryRevocationStore) RevokeToken(jti string, userID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range r.tokens {
+		if exp.Before(now) {
+			delete(r.tokens, id)
+		}
+	}
+	r.tokens[jti] = expiresAt
+
+	return nil
+}
+
+func (r *memoryRevocationStore) RevokeAllForUser(userID string, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked[userID] = before.Truncate(time.Millisecond)
+
+	return nil
+}
+
+func (r *memoryRevocationStore) IsRevoked(jti string, userID string, issuedAt time.Time) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.tokens[jti]; jti != "" && ok {
+		return true, nil
+	}
+
+	before, ok := r.revoked[userID]
+	if !ok {
+		return false, nil
+	}
+
+	return !issuedAt.After(before), nil
+}
+
+func (r *memoryRevocationStore) DeleteExpiredTokens(before time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, exp := range r.tokens {
+		if deleted >= int64(limit) {
+			break
+		}
+		if exp.Before(before) {
+			delete(r.tokens, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
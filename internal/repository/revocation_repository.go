@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore records access tokens that must be rejected before they
+// expire, either one at a time by jti or every token issued to a user up to
+// a point in time.
+type RevocationStore interface {
+	RevokeToken(jti string, userID string, expiresAt time.Time) error
+	RevokeAllForUser(userID string, before time.Time) error
+	IsRevoked(jti string, userID string, issuedAt time.Time) (bool, error)
+	DeleteExpiredTokens(before time.Time, limit int) (int64, error)
+}
+
+type revocationStore struct {
+	db *gorm.DB
+}
+
+func NewRevocationStore(db *gorm.DB) RevocationStore {
+
+	return &revocationStore{db: db}
+}
+
+func (r *revocationStore) RevokeToken(jti string, userID string, expiresAt time.Time) error {
+	tx := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (r *revocationStore) RevokeAllForUser(userID string, before time.Time) error {
+	tx := r.db.Save(&models.UserRevocation{UserID: userID, RevokedBefore: before.Truncate(time.Millisecond)})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (r *revocationStore) IsRevoked(jti string, userID string, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		var count int64
+		tx := r.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+		if tx.Error != nil {
+			return false, tx.Error
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	var revocation models.UserRevocation
+	tx := r.db.Where("user_id = ?", userID).First(&revocation)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	return !issuedAt.After(revocation.RevokedBefore), nil
+}
+
+// DeleteExpiredTokens deletes up to limit revoked tokens that expired before
+// the given time, which no longer need blocking, and returns how many were
+// deleted.
+func (r *revocationStore) DeleteExpiredTokens(before time.Time, limit int) (int64, error) {
+	var jtis []string
+	tx := r.db.Model(&models.RevokedToken{}).
+		Where("expires_at < ?", before).
+		Limit(limit).
+		Pluck("jti", &jtis)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(jtis) == 0 {
+		return 0, nil
+	}
+
+	tx = r.db.Where("jti IN ?", jtis).Delete(&models.RevokedToken{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return tx.RowsAffected, nil
+}
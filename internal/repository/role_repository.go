@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleRepository interface {
+	GetRoles() ([]models.Role, error)
+	GetRoleByName(name string) (models.Role, error)
+	AddUserRole(userID string, roleName string) error
+	RemoveUserRole(userID string, roleName string) error
+	EnsureRoles(roles []models.Role) error
+	BackfillUserRoles() error
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+
+	return &roleRepository{db: db}
+}
+
+func (r *roleRepository) GetRoles() ([]models.Role, error) {
+	var roles []models.Role
+	tx := r.db.Order("name").Find(&roles)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return roles, nil
+}
+
+func (r *roleRepository) GetRoleByName(name string) (models.Role, error) {
+	var role models.Role
+	tx := r.db.Where("name = ?", name).First(&role)
+	if tx.Error != nil {
+		return models.Role{}, tx.Error
+	}
+
+	return role, nil
+}
+
+func (r *roleRepository) AddUserRole(userID string, roleName string) error {
+	tx := r.db.Table("user_roles").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]interface{}{"user_id": userID, "role_name": roleName})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// RemoveUserRole takes the role away from the user, including through the
+// legacy role column, so a later backfill can't grant it again.
+func (r *roleRepository) RemoveUserRole(userID string, roleName string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM user_roles WHERE user_id = ? AND role_name = ?", userID, roleName).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec("UPDATE users SET role = '' WHERE id = ? AND role = ?", userID, roleName).Error
+	})
+}
+
+// EnsureRoles creates any of roles that don't exist yet. Existing roles are
+// left alone so permissions edited in the database aren't overwritten.
+func (r *roleRepository) EnsureRoles(roles []models.Role) error {
+	tx := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&roles)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+// BackfillUserRoles grants every user the role stored in their legacy role
+// column if they don't hold it through user_roles yet, then clears the
+// column so each legacy role is only migrated once.
+func (r *roleRepository) BackfillUserRoles() error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO user_roles (user_id, role_name)
+			SELECT u.id, u.role FROM users u
+			WHERE u.role IN (SELECT name FROM roles)
+			AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id AND ur.role_name = u.role)`).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("UPDATE users SET role = '' WHERE role <> ''").Error
+	})
+}
@@ -1,19 +1,30 @@
 package service
 
 import (
-	"github.com/gin-gonic/gin"
+	"context"
+	"errors"
+
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
 	"github.com/tanush-128/openzo_backend/user/internal/repository"
 	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrForbidden       = errors.New("insufficient permissions")
 )
 
+// AddressService acts for the caller authenticated by claims, who may only
+// reach their own addresses unless they are an admin.
 type AddressService interface {
 
 	//CRUD
-	CreateAddress(ctx *gin.Context, req models.Address) (models.Address, error)
-	GetAddressByID(ctx *gin.Context, id string) (models.Address, error)
-	GetAddressesByUserId(ctx *gin.Context, user_id string) ([]models.Address, error)
-	UpdateAddress(ctx *gin.Context, req models.Address) (models.Address, error)
+	CreateAddress(ctx context.Context, claims *middlewares.Claims, req models.Address) (models.Address, error)
+	GetAddressByID(ctx context.Context, claims *middlewares.Claims, id string) (models.Address, error)
+	GetAddressesByUserId(ctx context.Context, claims *middlewares.Claims, user_id string) ([]models.Address, error)
+	UpdateAddress(ctx context.Context, claims *middlewares.Claims, req models.Address) (models.Address, error)
 }
 type addressService struct {
 	addressRepository repository.AddressRepository
@@ -23,7 +34,24 @@ func NewAddressService(addressRepository repository.AddressRepository) AddressSe
 	return &addressService{addressRepository: addressRepository}
 }
 
-func (s *addressService) CreateAddress(ctx *gin.Context, req models.Address) (models.Address, error) {
+// authorize allows the request if the authenticated user is userID or an
+// admin.
+func (s *addressService) authorize(claims *middlewares.Claims, userID string) error {
+	if !claims.IsSelfOrRole(userID, models.RoleAdmin) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func (s *addressService) CreateAddress(ctx context.Context, claims *middlewares.Claims, req models.Address) (models.Address, error) {
+
+	if req.UserId == "" {
+		req.UserId = claims.UserID
+	}
+	if err := s.authorize(claims, req.UserId); err != nil {
+		return models.Address{}, err
+	}
 
 	if req.Latitude != "" && req.Longitude != "" {
 		location, err := utils.GetLocation(req.Latitude, req.Longitude)
@@ -47,16 +75,27 @@ func (s *addressService) CreateAddress(ctx *gin.Context, req models.Address) (mo
 	return createdAddress, nil
 }
 
-func (s *addressService) GetAddressByID(ctx *gin.Context, id string) (models.Address, error) {
+func (s *addressService) GetAddressByID(ctx context.Context, claims *middlewares.Claims, id string) (models.Address, error) {
 	address, err := s.addressRepository.GetAddressByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Address{}, ErrAddressNotFound
+	}
 	if err != nil {
 		return models.Address{}, err
 	}
 
+	if err := s.authorize(claims, address.UserId); err != nil {
+		return models.Address{}, err
+	}
+
 	return address, nil
 }
 
-func (s *addressService) GetAddressesByUserId(ctx *gin.Context, user_id string) ([]models.Address, error) {
+func (s *addressService) GetAddressesByUserId(ctx context.Context, claims *middlewares.Claims, user_id string) ([]models.Address, error) {
+	if err := s.authorize(claims, user_id); err != nil {
+		return nil, err
+	}
+
 	addresses, err := s.addressRepository.GetAddressesByUserID(user_id)
 	if err != nil {
 		return nil, err
@@ -65,7 +104,15 @@ func (s *addressService) GetAddressesByUserId(ctx *gin.Context, user_id string)
 	return addresses, nil
 }
 
-func (s *addressService) UpdateAddress(ctx *gin.Context, req models.Address) (models.Address, error) {
+func (s *addressService) UpdateAddress(ctx context.Context, claims *middlewares.Claims, req models.Address) (models.Address, error) {
+
+	existing, err := s.GetAddressByID(ctx, claims, req.ID)
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	// Addresses can't be moved to another user.
+	req.UserId = existing.UserId
 
 	updatedAddress, err := s.addressRepository.UpdateAddress(req)
 	if err != nil {
@@ -2,13 +2,17 @@ package service
 
 import (
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
 )
 
 type UserSignInRequest struct {
@@ -18,56 +22,117 @@ type UserSignInRequest struct {
 
 }
 
-func (s *userService) UserSignIn(ctx *gin.Context, req UserSignInRequest) (string, error) {
+var (
+	ErrInvalidCredentials = errors.New("invalid mobile number or password")
+	ErrPasswordNotSet     = errors.New("this account has no password, sign in with an OTP instead")
+)
+
+// SignInResult holds the tokens of a completed sign in or, for accounts with
+// two-factor authentication, the challenge to complete at /signin/mfa.
+type SignInResult struct {
+	Tokens    *TokenPair
+	Challenge *MFAChallenge
+}
+
+// UserSignIn checks a password sign in. Failures are counted per account and
+// per client IP, and both are throttled by the lockout service. The account
+// throttle is only reset once any second factor has been checked too.
+func (s *userService) UserSignIn(ctx *gin.Context, req UserSignInRequest) (SignInResult, error) {
+	ip := ctx.ClientIP()
+	if err := s.lockoutService.Check(models.ThrottleScopeIP, ip); err != nil {
+		return SignInResult{}, err
+	}
 
-	user, err := s.userRepository.GetUserByMobile(req.Mobile)
+	mobile, err := phonenumber.Normalize(req.Mobile, s.phoneConfig.DefaultRegion)
 	if err != nil {
-		return "", err
+		return SignInResult{}, err
+	}
+
+	user, err := s.userRepository.GetUserByMobile(mobile)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := s.lockoutService.RecordFailure(models.ThrottleScopeIP, ip); err != nil {
+			return SignInResult{}, err
+		}
+		return SignInResult{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return SignInResult{}, err
 	}
 
-	password := ""
-	if user.Password != nil {
-		password = *user.Password
-		fmt.Println(password)
+	if err := s.lockoutService.Check(models.ThrottleScopeAccount, user.ID); err != nil {
+		return SignInResult{}, err
 	}
 
-	if password != "" {
-		err = utils.CheckPasswordHash(req.Password, password)
+	if user.Password == nil || *user.Password == "" {
+		return SignInResult{}, ErrPasswordNotSet
+	}
 
-		if err != nil {
-			return "", errors.New("invalid password")
+	if err := utils.CheckPasswordHash(req.Password, *user.Password); err != nil {
+		if err := s.lockoutService.RecordFailure(models.ThrottleScopeAccount, user.ID); err != nil {
+			return SignInResult{}, err
 		}
+		if err := s.lockoutService.RecordFailure(models.ThrottleScopeIP, ip); err != nil {
+			return SignInResult{}, err
+		}
+		return SignInResult{}, ErrInvalidCredentials
 	}
 
-	token, err := CreateJwtToken(user.ID)
+	result, err := s.mfaService.SignIn(ctx, user.ID)
 	if err != nil {
-		return token, err
+		return SignInResult{}, err
+	}
+	if result.Tokens != nil {
+		if err := s.lockoutService.Reset(models.ThrottleScopeAccount, user.ID); err != nil {
+			return SignInResult{}, err
+		}
 	}
 
-	return token, nil
+	return result, nil
 }
 
+// GetUserWithJWT returns the token's user with the roles the token carries,
+// so roles left out of sessions without two-factor authentication aren't
+// reported either.
 func (s *userService) GetUserWithJWT(ctx *gin.Context, token string) (models.User, error) {
-	_user := ctx.MustGet("user").(map[string]interface{})
-	_id := _user["user_id"].(string)
+	claims := ctx.MustGet("user").(*middlewares.Claims)
 
-	user, err := s.userRepository.GetUserByID(_id)
+	user, err := s.userRepository.GetUserByID(claims.UserID)
 	if err != nil {
 		return models.User{}, err
 	}
+	user.Roles = onlyRoles(user.Roles, claims.Roles)
 
 	return user, nil
 }
 
-func CreateJwtToken(id string) (string, error) {
-	claims := jwt.MapClaims{}
+func init() {
+	// Millisecond iat claims let a revocation reject every token issued up
+	// to it without also rejecting the ones issued right after.
+	jwt.TimePrecision = time.Millisecond
+}
 
-	claims["authorized"] = true
-	claims["user_id"] = id
-	claims["exp"] = time.Now().Add(time.Hour * 24 * 15).Unix()
+func CreateJwtToken(keys *middlewares.KeySet, cfg config.JWTConfig, user models.User, mfa bool) (string, error) {
+	now := time.Now()
+	claims := &middlewares.Claims{
+		UserID:      user.ID,
+		Roles:       user.RoleNames(),
+		Permissions: user.PermissionNames(),
+		Authorized:  true,
+		MFA:         mfa,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   user.ID,
+			Issuer:    cfg.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
+		},
+	}
+	if cfg.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{cfg.Audience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte("secret"))
+	tokenString, err := keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrEmailInUse is the repository's error, so it is the same however
+	// far down the clash is found.
+	ErrEmailInUse            = repository.ErrEmailInUse
+	ErrEmailUnchanged        = errors.New("email is already the account's email")
+	ErrInvalidEmail          = errors.New("invalid email")
+	ErrEmailChangeSuperseded = errors.New("this email change was cancelled or replaced by a newer one")
+)
+
+type EmailChangeRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// RequestEmailChange mails a confirmation link to email and, once it is
+// sent, sets it as the user's pending email. User.Email only changes once the
+// link is opened.
+func (s *otpService) RequestEmailChange(ctx context.Context, userId string, email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.userRepository.GetUserByID(userId)
+	if err != nil {
+		return err
+	}
+	if user.Email != nil && strings.EqualFold(*user.Email, email) {
+		return ErrEmailUnchanged
+	}
+
+	owner, err := s.userRepository.GetUserByEmail(email)
+	if err == nil && owner.ID != user.ID {
+		return ErrEmailInUse
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	token, err := generateLinkToken()
+	if err != nil {
+		return err
+	}
+
+	purposeConfig := s.cfg.OTP.ForPurpose(models.OTPPurposeEmailChange)
+
+	verificationId, err := s.sendEmailOTP(ctx, models.OTP{Email: email, UserID: user.ID, Purpose: models.OTPPurposeEmailChange}, token, "Confirm your Openzo email", func(verificationId string) string {
+		query := url.Values{}
+		query.Set("verification_id", verificationId)
+		query.Set("token", token)
+
+		return fmt.Sprintf("Open this link to use this address for your Openzo account:\n\n%s\n\nIt expires in %s. If you didn't ask for this, you can ignore this email.\n",
+			s.cfg.Mail.EmailChangeURL+"?"+query.Encode(), purposeConfig.TTL)
+	})
+	if err != nil {
+		return err
+	}
+
+	user.PendingEmail = &email
+	if _, err := s.userRepository.UpdateUser(user); err != nil {
+		s.otpRepository.DeleteOTP(verificationId)
+		return err
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange checks the link sent by RequestEmailChange and, if the
+// address is still the user's pending email, makes it their verified email.
+func (s *otpService) ConfirmEmailChange(ctx *gin.Context, verificationId string, token string) (models.User, error) {
+	_otp, err := s.checkOTP(verificationId, token, models.OTPPurposeEmailChange, func(_otp models.OTP) error {
+		if _otp.Email == "" || _otp.UserID == "" {
+			return errors.New("invalid email change link")
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := s.userRepository.GetUserByID(_otp.UserID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.PendingEmail == nil || !strings.EqualFold(*user.PendingEmail, _otp.Email) {
+		return models.User{}, ErrEmailChangeSuperseded
+	}
+
+	email := _otp.Email
+	user.Email = &email
+	user.PendingEmail = nil
+	user.EmailVerified = true
+
+	updatedUser, err := s.userRepository.UpdateUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return updatedUser, nil
+}
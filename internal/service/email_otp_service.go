@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
+)
+
+// GenerateEmailOTP emails a sign in code to the user with the given address.
+// Addresses without an account get a decoy verification id, so the answer
+// doesn't reveal which addresses are registered.
+func (s *otpService) GenerateEmailOTP(ctx *gin.Context, email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", ErrInvalidEmail
+	}
+
+	purposeConfig := s.cfg.OTP.ForPurpose(models.OTPPurposeEmailLogin)
+
+	code, err := generatedRandomOTP(purposeConfig.Length)
+	if err != nil {
+		return "", err
+	}
+
+	return s.sendEmailSignIn(ctx, models.OTP{Email: email, Purpose: models.OTPPurposeEmailLogin}, code, "Your Openzo sign in code", func(verificationId string) string {
+		return fmt.Sprintf("Your Openzo sign in code is %s.\n\nIt expires in %s. If you didn't try to sign in, you can ignore this email.\n",
+			code, purposeConfig.TTL)
+	})
+}
+
+// VerifyEmailOTP signs the user in with a code from GenerateEmailOTP and
+// marks their email as verified.
+func (s *otpService) VerifyEmailOTP(ctx *gin.Context, email string, verificationId string, otp string) (SignInResult, error) {
+	email = strings.TrimSpace(email)
+	_otp, err := s.checkOTP(verificationId, otp, models.OTPPurposeEmailLogin, func(_otp models.OTP) error {
+		if !strings.EqualFold(_otp.Email, email) {
+			return errors.New("invalid email")
+		}
+		return nil
+	})
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	return s.signInWithEmail(ctx, _otp.Email)
+}
+
+// SendMagicLink emails the user a link to a page that signs them in. Like
+// GenerateEmailOTP, it answers the same for addresses without an account.
+func (s *otpService) SendMagicLink(ctx *gin.Context, email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ErrInvalidEmail
+	}
+
+	token, err := generateLinkToken()
+	if err != nil {
+		return err
+	}
+
+	purposeConfig := s.cfg.OTP.ForPurpose(models.OTPPurposeMagicLink)
+
+	_, err = s.sendEmailSignIn(ctx, models.OTP{Email: email, Purpose: models.OTPPurposeMagicLink}, token, "Sign in to Openzo", func(verificationId string) string {
+		query := url.Values{}
+		query.Set("verification_id", verificationId)
+		query.Set("token", token)
+
+		return fmt.Sprintf("Open this link to sign in to Openzo:\n\n%s\n\nIt expires in %s and can only be used once. If you didn't try to sign in, you can ignore this email.\n",
+			s.cfg.Mail.MagicLinkURL+"?"+query.Encode(), purposeConfig.TTL)
+	})
+
+	return err
+}
+
+// VerifyMagicLink signs the user in with the verification id and token from
+// a magic link and marks their email as verified.
+func (s *otpService) VerifyMagicLink(ctx *gin.Context, verificationId string, token string) (SignInResult, error) {
+	_otp, err := s.checkOTP(verificationId, token, models.OTPPurposeMagicLink, func(_otp models.OTP) error {
+		if _otp.Email == "" {
+			return errors.New("invalid magic link")
+		}
+		return nil
+	})
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	return s.signInWithEmail(ctx, _otp.Email)
+}
+
+// generateLinkToken returns a random token to put in an emailed link.
+func generateLinkToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sendEmailSignIn mails a sign in code or link to otp.Email in the
+// background and returns its verification id. An address without an account
+// gets a decoy id and no mail. Both count against the rate limits, so
+// callers can't tell them apart.
+func (s *otpService) sendEmailSignIn(ctx context.Context, otp models.OTP, code string, subject string, body func(verificationId string) string) (string, error) {
+	_, err := s.userRepository.GetUserByEmail(otp.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := s.rateLimiter.Acquire(strings.ToLower(otp.Email), clientIP(ctx)); err != nil {
+			return "", err
+		}
+		return uuid.New().String(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := s.storeEmailOTP(ctx, otp, code)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		if err := s.mailer.Send(context.Background(), stored.Email, subject, body(stored.ID)); err != nil {
+			log.Printf("failed to send email to %s: %v", stored.Email, err)
+			s.otpRepository.DeleteOTP(stored.ID)
+		}
+	}()
+
+	return stored.ID, nil
+}
+
+// sendEmailOTP stores code as an OTP for otp.Email and otp.Purpose, and
+// mails it. The body is built once the OTP is stored, since links contain
+// its id.
+func (s *otpService) sendEmailOTP(ctx context.Context, otp models.OTP, code string, subject string, body func(verificationId string) string) (string, error) {
+	stored, err := s.storeEmailOTP(ctx, otp, code)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.mailer.Send(ctx, stored.Email, subject, body(stored.ID)); err != nil {
+		s.otpRepository.DeleteOTP(stored.ID)
+		log.Printf("failed to send email to %s: %v", stored.Email, err)
+		return "", fmt.Errorf("%w: %v", ErrOTPDeliveryFailed, err)
+	}
+
+	return stored.ID, nil
+}
+
+// storeEmailOTP counts a send to otp.Email against the rate limits and
+// stores code as an OTP for it.
+func (s *otpService) storeEmailOTP(ctx context.Context, otp models.OTP, code string) (models.OTP, error) {
+	if err := s.rateLimiter.Acquire(strings.ToLower(otp.Email), clientIP(ctx)); err != nil {
+		return models.OTP{}, err
+	}
+
+	otp.Channel = models.OTPChannelEmail
+	otp.HashedOTP = utils.HashOTPWithSecret(code, s.cfg.OTP.Secret)
+	otp.ExpiresAt = time.Now().Add(s.cfg.OTP.ForPurpose(otp.Purpose).TTL)
+
+	return s.otpRepository.CreateOTP(otp)
+}
+
+// clientIP returns the IP of the HTTP client behind ctx, or "" for calls
+// made over gRPC, which are only limited per recipient.
+func clientIP(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok {
+		return c.ClientIP()
+	}
+
+	return ""
+}
+
+// signInWithEmail marks the user's email as verified and signs them in,
+// through the second factor if they have one.
+func (s *otpService) signInWithEmail(ctx *gin.Context, email string) (SignInResult, error) {
+	user, err := s.userRepository.GetUserByEmail(email)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		if _, err := s.userRepository.UpdateUser(user); err != nil {
+			return SignInResult{}, err
+		}
+	}
+
+	return s.mfaService.SignIn(ctx, user.ID)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestEmailSignInAnswersUnknownAddressesLikeKnownOnes(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	email := "known@example.com"
+	if _, err := s.userRepository.CreateUser(models.User{Phone: "+919876543210", Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestContext(nil)
+
+	knownID, err := s.GenerateEmailOTP(ctx, "Known@Example.com")
+	if err != nil {
+		t.Fatalf("known address: %v", err)
+	}
+	unknownID, err := s.GenerateEmailOTP(ctx, "unknown@example.com")
+	if err != nil {
+		t.Fatalf("unknown address: %v", err)
+	}
+	if len(unknownID) != len(knownID) {
+		t.Errorf("decoy id %q doesn't look like a real one, %q", unknownID, knownID)
+	}
+	if _, err := s.otpRepository.GetOTPByID(unknownID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("an OTP was stored for the unknown address: %v", err)
+	}
+
+	if err := s.SendMagicLink(ctx, "unknown@example.com"); err != nil {
+		t.Errorf("magic link to an unknown address: %v", err)
+	}
+	if err := s.SendMagicLink(ctx, "known@example.com"); err != nil {
+		t.Fatalf("magic link to a known address: %v", err)
+	}
+
+	sent := waitForEmails(t, s.mailer, 2)
+	for _, email := range sent {
+		if !strings.EqualFold(email.To, "known@example.com") {
+			t.Errorf("mail was sent to %s", email.To)
+		}
+	}
+}
+
+func TestEmailSignInRateLimitsUnknownAddresses(t *testing.T) {
+	s := newTestOTPService(t, &config.Config{OTPRateLimit: config.OTPRateLimitConfig{PhonePerHour: 1}})
+	ctx := newTestContext(nil)
+
+	if _, err := s.GenerateEmailOTP(ctx, "unknown@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var throttled *ThrottledError
+	if _, err := s.GenerateEmailOTP(ctx, "UNKNOWN@example.com"); !errors.As(err, &throttled) {
+		t.Fatalf("second request = %v, want a *ThrottledError", err)
+	}
+}
+
+func TestMagicLinkPointsAtTheConfiguredPage(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	email := "known@example.com"
+	if _, err := s.userRepository.CreateUser(models.User{Phone: "+919876543210", Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SendMagicLink(newTestContext(nil), email); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := waitForEmails(t, s.mailer, 1)
+	if !strings.Contains(sent[0].Body, "https://openzo.example/email/magic-link/verify?") {
+		t.Errorf("magic link mail doesn't link to the page:\n%s", sent[0].Body)
+	}
+}
+
+func waitForEmails(t *testing.T, mailer *RecordingMailer, n int) []SentEmail {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sent := mailer.Sent(); len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails were sent, want %d", len(mailer.Sent()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Account event types.
+const (
+	EventPhoneChanged = "user.phone_changed"
+)
+
+// EventPublisher publishes account events for other services. key is the id
+// of the user the event is about, so a user's events stay in order.
+type EventPublisher interface {
+	Publish(ctx context.Context, key string, event interface{}) error
+}
+
+// PhoneChangedEvent is published when a user's phone number changes.
+type PhoneChangedEvent struct {
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	OldPhone  string    `json:"old_phone"`
+	NewPhone  string    `json:"new_phone"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// kafkaEventPublisher produces events as JSON to a Kafka topic. Delivery
+// reports are read from the producer's Events channel by its owner.
+type kafkaEventPublisher struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func NewKafkaEventPublisher(producer *kafka.Producer, topic string) EventPublisher {
+	return &kafkaEventPublisher{producer: producer, topic: topic}
+}
+
+func (p *kafkaEventPublisher) Publish(ctx context.Context, key string, event interface{}) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(key),
+		Value:          value,
+	}, nil)
+}
+
+// writerEventPublisher writes each event as a line instead of publishing
+// it, for local development.
+type writerEventPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterEventPublisher(w io.Writer) EventPublisher {
+	return &writerEventPublisher{w: w}
+}
+
+func (p *writerEventPublisher) Publish(ctx context.Context, key string, event interface{}) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err = fmt.Fprintf(p.w, "%s event %s: %s\n", time.Now().Format(time.RFC3339), key, value)
+
+	return err
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	userpb "github.com/tanush-128/openzo_backend/user/internal/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) ListAddresses(ctx context.Context, req *userpb.UserId) (*userpb.Addresses, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.AddressService.GetAddressesByUserId(ctx, claims, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := &userpb.Addresses{Addresses: make([]*userpb.Address, 0, len(addresses))}
+	for _, address := range addresses {
+		res.Addresses = append(res.Addresses, toAddressPB(address))
+	}
+
+	return res, nil
+}
+
+func (s *Server) GetAddress(ctx context.Context, req *userpb.AddressId) (*userpb.Address, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := s.AddressService.GetAddressByID(ctx, claims, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toAddressPB(address), nil
+}
+
+// GetDefaultAddress returns the address the user chose as their default,
+// failing with NotFound if they haven't chosen one.
+func (s *Server) GetDefaultAddress(ctx context.Context, req *userpb.UserId) (*userpb.Address, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.IsSelfOrRole(req.GetId(), models.RoleAdmin) {
+		return nil, grpcError(ErrForbidden)
+	}
+
+	user, err := s.UserService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if user.DefaultAddress == "" {
+		return nil, status.Errorf(codes.NotFound, "user %s has no default address", user.ID)
+	}
+
+	address, err := s.AddressService.GetAddressByID(ctx, claims, user.DefaultAddress)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toAddressPB(address), nil
+}
+
+func toAddressPB(address models.Address) *userpb.Address {
+	return &userpb.Address{
+		Id:             address.ID,
+		UserId:         address.UserId,
+		Name:           address.Name,
+		PhoneNo:        address.PhoneNo,
+		Tag:            address.Tag,
+		Area:           address.Area,
+		Building:       address.Building,
+		NearbyLandmark: address.NearbyLandmark,
+		Address:        address.Address,
+		Pincode:        address.Pincode,
+		City:           address.City,
+		State:          address.State,
+		Latitude:       address.Latitude,
+		Longitude:      address.Longitude,
+	}
+}
@@ -1,24 +1,38 @@
 package service
+
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strings"
 
 	"github.com/tanush-128/openzo_backend/user/config"
 	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
 	userpb "github.com/tanush-128/openzo_backend/user/internal/pb"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 
 	// "github.com/tanush-128/openzo_backend/store/internal/pb"
 	"github.com/tanush-128/openzo_backend/user/internal/repository"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
 )
 
 type Server struct {
 	userpb.UserServiceServer
 	UserRepository repository.UserRepository
 	UserService    UserService
+	AddressService AddressService
+	Auth           *middlewares.Authenticator
+	Phone          config.PhoneConfig
+	ServiceTokens  []string
 }
 
 func GrpcServer(
@@ -42,25 +56,106 @@ func GrpcServer(
 }
 
 func (s *Server) GetUserWithJWT(ctx context.Context, req *userpb.Token) (*userpb.User, error) {
-	claims, err := middlewares.ValidateJwtToken(req.Token)
+	claims, err := s.Auth.ValidateJwtToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.UserRepository.GetUserByID(claims.UserID)
 	if err != nil {
 		return nil, err
 	}
-	user, err := s.UserRepository.GetUserByID(claims["user_id"].(string))
+	user.Roles = onlyRoles(user.Roles, claims.Roles)
+
+	return toUserPB(user), nil
+}
+
+// this is Tanush Agarwal from openzo backend
+
+// GetUserIdWithPhoneNo returns the id of the user with the given phone
+// number. With create_if_missing, an unverified user is created for an
+// unknown number, so a walk-in customer can be linked to the account they
+// later sign in to with that number. Only backend services may call it, as
+// it tells whether any number is registered.
+func (s *Server) GetUserIdWithPhoneNo(ctx context.Context, req *userpb.PhoneNo) (*userpb.UserId, error) {
+	claims, err := s.caller(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if claims != serviceClaims {
+		return nil, grpcError(ErrForbidden)
+	}
+
+	phone, err := phonenumber.Normalize(req.GetPhoneNo(), s.Phone.DefaultRegion)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.UserRepository.GetUserByMobile(phone)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if !req.GetCreateIfMissing() {
+			return nil, status.Errorf(codes.NotFound, "no user with phone number %s", phone)
+		}
+
+		user, err = s.UserRepository.CreateUser(models.User{Phone: phone})
+		if err != nil {
+			// Another request may have created the user first.
+			user, err = s.UserRepository.GetUserByMobile(phone)
+		}
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &userpb.UserId{Id: user.ID}, nil
+}
+
+// serviceClaims are used for calls made by other backend services on their
+// own behalf, which may act on any user.
+var serviceClaims = &middlewares.Claims{Roles: []string{models.RoleAdmin}}
+
+// caller authenticates the gRPC call in ctx. A call is made either by the
+// user of the "authorization" bearer token in its metadata, or by a backend
+// service presenting one of the configured "x-service-token" values.
+func (s *Server) caller(ctx context.Context) (*middlewares.Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("authorization"); len(values) > 0 {
+		claims, err := s.Auth.ValidateJwtToken(strings.TrimPrefix(values[0], "Bearer "))
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return claims, nil
+	}
+
+	if values := md.Get("x-service-token"); len(values) > 0 {
+		for _, token := range s.ServiceTokens {
+			if token != "" && subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) == 1 {
+				return serviceClaims, nil
+			}
+		}
+
+		return nil, status.Error(codes.Unauthenticated, "invalid service token")
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "authorization or service token is required")
+}
 
-	role := userpb.Role_USER
-	if user.Role == "ADMIN" {
-		role = userpb.Role_ADMIN
+// grpcError converts a service error to a gRPC status error.
+func grpcError(err error) error {
+	var throttled *ThrottledError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, ErrAddressNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, phonenumber.ErrInvalid), errors.Is(err, ErrTooManyUserIDs), errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrUnknownPincode):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrEmailInUse):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.As(err, &throttled):
+		return status.Error(codes.ResourceExhausted, err.Error())
 	}
 
-	return &userpb.User{
-		Id:         user.ID,
-		Phone:      user.Phone,
-		IsVerified: user.IsVerified,
-		Role:       role,
-	}, nil
+	return status.Error(codes.Internal, err.Error())
 }
-// this is Tanush Agarwal from openzo backend
\ No newline at end of file
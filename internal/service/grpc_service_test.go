@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	userpb "github.com/tanush-128/openzo_backend/user/internal/pb"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetUserIdWithPhoneNoRequiresAServiceToken(t *testing.T) {
+	db := newTestDB(t, &models.User{}, &models.Role{}, &models.RevokedToken{}, &models.UserRevocation{})
+	cfg := config.JWTConfig{
+		SigningKeyID: "test",
+		Keys:         []config.JWTKeyConfig{{ID: "test", Secret: "test-signing-secret"}},
+	}
+	keys, err := middlewares.NewKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepository := repository.NewUserRepository(db)
+	user, err := userRepository.CreateUser(models.User{Phone: "+919876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{
+		UserRepository: userRepository,
+		Auth:           middlewares.NewAuthenticator(cfg, keys, repository.NewRevocationStore(db)),
+		Phone:          config.PhoneConfig{DefaultRegion: "IN"},
+		ServiceTokens:  []string{"test-service-token"},
+	}
+
+	userToken, err := keys.Sign(middlewares.Claims{
+		UserID: user.ID,
+		Roles:  []string{models.RoleAdmin},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "token-1",
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Second)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		metadata metadata.MD
+		want     codes.Code
+	}{
+		{"no credentials", metadata.MD{}, codes.Unauthenticated},
+		{"wrong service token", metadata.Pairs("x-service-token", "guess"), codes.Unauthenticated},
+		{"user token", metadata.Pairs("authorization", "Bearer "+userToken), codes.PermissionDenied},
+		{"service token", metadata.Pairs("x-service-token", "test-service-token"), codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.metadata)
+			id, err := server.GetUserIdWithPhoneNo(ctx, &userpb.PhoneNo{PhoneNo: "98765 43210"})
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("GetUserIdWithPhoneNo() = %v, want code %v", err, tt.want)
+			}
+			if err == nil && id.GetId() != user.ID {
+				t.Errorf("GetUserIdWithPhoneNo() = %q, want %q", id.GetId(), user.ID)
+			}
+		})
+	}
+}
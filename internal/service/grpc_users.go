@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	userpb "github.com/tanush-128/openzo_backend/user/internal/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) GetUser(ctx context.Context, req *userpb.UserId) (*userpb.User, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.IsSelfOrRole(req.GetId(), models.RoleAdmin) {
+		return nil, grpcError(ErrForbidden)
+	}
+
+	user, err := s.UserService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toUserPB(user), nil
+}
+
+// BatchGetUsers returns the users with the given ids. Ids without a user
+// are left out of the response, and at most USERS.BATCH_MAX_IDS ids may be
+// asked for.
+func (s *Server) BatchGetUsers(ctx context.Context, req *userpb.UserIds) (*userpb.Users, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.HasRole(models.RoleAdmin) {
+		return nil, grpcError(ErrForbidden)
+	}
+
+	found, err := s.UserService.GetUsersByIDs(ctx, req.GetIds())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	users := make(map[string]*userpb.User, len(found))
+	for id, user := range found {
+		users[id] = toUserPB(user)
+	}
+
+	return &userpb.Users{Users: users}, nil
+}
+
+// UpdateUser sets the non-empty fields of req on the user with req's id.
+// As over HTTP, the phone number, verification flags and roles can't be
+// changed, and a new email only replaces the current one once confirmed.
+func (s *Server) UpdateUser(ctx context.Context, req *userpb.User) (*userpb.User, error) {
+	claims, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if !claims.IsSelfOrRole(req.GetId(), models.RoleAdmin) {
+		return nil, grpcError(ErrForbidden)
+	}
+
+	user, err := s.UserService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	if req.GetDefaultAddress() != "" && req.GetDefaultAddress() != user.DefaultAddress {
+		address, err := s.AddressService.GetAddressByID(ctx, claims, req.GetDefaultAddress())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if address.UserId != user.ID {
+			return nil, status.Error(codes.InvalidArgument, "default address belongs to another user")
+		}
+		user.DefaultAddress = address.ID
+	}
+
+	setString(&user.Name, req.GetName())
+	setString(&user.Email, req.GetEmail())
+	setString(&user.NotificationToken, req.GetNotificationToken())
+	if location := req.GetLocation(); location != nil {
+		setString(&user.Latitude, location.GetLatitude())
+		setString(&user.Longitude, location.GetLongitude())
+		setString(&user.Address, location.GetAddress())
+		setString(&user.Pincode, location.GetPincode())
+		setString(&user.City, location.GetCity())
+		setString(&user.State, location.GetState())
+		setString(&user.Country, location.GetCountry())
+	}
+
+	updatedUser, err := s.UserService.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return toUserPB(updatedUser), nil
+}
+
+// setString points field at value, unless value is empty or unchanged.
+func setString(field **string, value string) {
+	if value == "" || (*field != nil && **field == value) {
+		return
+	}
+
+	*field = &value
+}
+
+func toUserPB(user models.User) *userpb.User {
+	role := userpb.Role_USER
+	var roles []userpb.Role
+	for _, name := range user.RoleNames() {
+		r, ok := userpb.Role_value[name]
+		if !ok {
+			continue
+		}
+		roles = append(roles, userpb.Role(r))
+		if name == models.RoleAdmin {
+			role = userpb.Role_ADMIN
+		}
+	}
+
+	return &userpb.User{
+		Id:                user.ID,
+		Phone:             user.Phone,
+		IsVerified:        user.IsVerified,
+		Role:              role,
+		Roles:             roles,
+		Permissions:       user.PermissionNames(),
+		Name:              stringValue(user.Name),
+		Email:             stringValue(user.Email),
+		EmailVerified:     user.EmailVerified,
+		NotificationToken: stringValue(user.NotificationToken),
+		DefaultAddress:    user.DefaultAddress,
+		Location: &userpb.Location{
+			Latitude:  stringValue(user.Latitude),
+			Longitude: stringValue(user.Longitude),
+			Address:   stringValue(user.Address),
+			Pincode:   stringValue(user.Pincode),
+			City:      stringValue(user.City),
+			State:     stringValue(user.State),
+			Country:   stringValue(user.Country),
+		},
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+// ThrottledError is returned when a request is refused because of earlier
+// attempts, such as failed sign ins or OTPs already sent. RetryAfter is how
+// long the client has to wait.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many attempts, retry in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+type LockoutService interface {
+	Check(scope string, subject string) error
+	RecordFailure(scope string, subject string) error
+	Reset(scope string, subject string) error
+
+	GetLockouts(ctx *gin.Context) ([]models.LoginThrottle, error)
+	ClearLockout(ctx *gin.Context, scope string, subject string) error
+}
+
+type lockoutService struct {
+	loginThrottleRepository repository.LoginThrottleRepository
+	cfg                     config.LockoutConfig
+}
+
+func NewLockoutService(loginThrottleRepository repository.LoginThrottleRepository, cfg config.LockoutConfig) LockoutService {
+	return &lockoutService{loginThrottleRepository: loginThrottleRepository, cfg: cfg}
+}
+
+// Check returns a *ThrottledError if the subject is locked out or, for
+// accounts, still inside the delay that follows its last failure.
+func (s *lockoutService) Check(scope string, subject string) error {
+	throttle, err := s.loginThrottleRepository.GetThrottle(scope, subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		return &ThrottledError{RetryAfter: throttle.LockedUntil.Sub(now)}
+	}
+
+	if scope == models.ThrottleScopeAccount && throttle.Failures > 0 && !s.forgotten(throttle, now) {
+		if next := throttle.LastFailureAt.Add(s.delay(throttle.Failures)); next.After(now) {
+			return &ThrottledError{RetryAfter: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+func (s *lockoutService) RecordFailure(scope string, subject string) error {
+	maxFailures := s.cfg.MaxAccountFailures
+	if scope == models.ThrottleScopeIP {
+		maxFailures = s.cfg.MaxIPFailures
+	}
+
+	_, err := s.loginThrottleRepository.UpdateThrottle(scope, subject, func(throttle *models.LoginThrottle) {
+		now := time.Now()
+		if s.forgotten(*throttle, now) {
+			throttle.Failures = 0
+		}
+
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		if maxFailures > 0 && throttle.Failures >= maxFailures {
+			lockedUntil := now.Add(s.cfg.LockoutDuration)
+			throttle.LockedUntil = &lockedUntil
+		}
+	})
+
+	return err
+}
+
+func (s *lockoutService) Reset(scope string, subject string) error {
+	return s.loginThrottleRepository.DeleteThrottle(scope, subject)
+}
+
+func (s *lockoutService) GetLockouts(ctx *gin.Context) ([]models.LoginThrottle, error) {
+	return s.loginThrottleRepository.GetActiveThrottles(time.Now().Add(-s.cfg.FailureWindow))
+}
+
+func (s *lockoutService) ClearLockout(ctx *gin.Context, scope string, subject string) error {
+	return s.Reset(scope, subject)
+}
+
+// forgotten reports whether the throttle's failures are old enough to be
+// ignored. Failures are kept while a lockout is still running.
+func (s *lockoutService) forgotten(throttle models.LoginThrottle, now time.Time) bool {
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		return false
+	}
+
+	return s.cfg.FailureWindow > 0 && throttle.LastFailureAt.Add(s.cfg.FailureWindow).Before(now)
+}
+
+// delay is the wait imposed after the given number of consecutive failures.
+func (s *lockoutService) delay(failures int) time.Duration {
+	delay := s.cfg.BaseDelay
+	for i := 1; i < failures && delay < s.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if s.cfg.MaxDelay > 0 && delay > s.cfg.MaxDelay {
+		delay = s.cfg.MaxDelay
+	}
+
+	return delay
+}
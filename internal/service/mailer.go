@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+)
+
+// Mailer sends a plain text email.
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// NewMailer returns the mailer selected by cfg.Provider.
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", "spool":
+		return NewSpoolMailer(cfg.SpoolDir, cfg.From), nil
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "recording":
+		return NewRecordingMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.Provider)
+	}
+}
+
+func buildMessage(from string, to string, subject string, body string) []byte {
+	var msg strings.Builder
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(msg.String())
+}
+
+// validAddress rejects addresses that could inject extra headers.
+func validAddress(address string) error {
+	if strings.ContainsAny(address, "\r\n") {
+		return fmt.Errorf("invalid email address %q", address)
+	}
+
+	return nil
+}
+
+// smtpMailer sends mail through an SMTP relay, using STARTTLS when the
+// server offers it.
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host string, port int, username string, password string, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpMailer{addr: net.JoinHostPort(host, strconv.Itoa(port)), auth: auth, from: from}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	if err := validAddress(to); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, buildMessage(m.from, to, subject, body))
+}
+
+// spoolMailer writes each message to a .eml file in a directory instead of
+// sending it, for local development.
+type spoolMailer struct {
+	dir  string
+	from string
+}
+
+func NewSpoolMailer(dir string, from string) Mailer {
+	return &spoolMailer{dir: dir, from: from}
+}
+
+func (m *spoolMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	if err := validAddress(to); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), hex.EncodeToString(suffix))
+
+	return os.WriteFile(filepath.Join(m.dir, name), buildMessage(m.from, to, subject, body), 0o600)
+}
+
+// SentEmail is a message captured by RecordingMailer.
+type SentEmail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// RecordingMailer keeps every email it is asked to send so tests can read
+// them back. Setting Err makes every send fail with it.
+type RecordingMailer struct {
+	mu   sync.Mutex
+	sent []SentEmail
+	Err  error
+}
+
+func NewRecordingMailer() *RecordingMailer {
+	return &RecordingMailer{}
+}
+
+func (m *RecordingMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, SentEmail{To: to, Subject: subject, Body: body})
+
+	return nil
+}
+
+// Sent returns the emails sent so far.
+func (m *RecordingMailer) Sent() []SentEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]SentEmail(nil), m.sent...)
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled      = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode      = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA token")
+)
+
+// TOTPEnrollment is shown to the user once so they can add the secret to an
+// authenticator app, usually by scanning the URI as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is returned by sign in, whatever the first factor, when the
+// account has two-factor authentication enabled.
+type MFAChallenge struct {
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// MFACodeRequest carries either a code from the authenticator app or one of
+// the user's recovery codes.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFASignInRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	MFACodeRequest
+}
+
+type MFAService interface {
+	EnrollTOTP(ctx *gin.Context) (TOTPEnrollment, error)
+	ConfirmTOTP(ctx *gin.Context, code string) ([]string, error)
+	DisableTOTP(ctx *gin.Context, req MFACodeRequest) error
+	RegenerateRecoveryCodes(ctx *gin.Context, req MFACodeRequest) ([]string, error)
+
+	IsEnabled(userID string) (bool, error)
+	SignIn(ctx *gin.Context, userID string) (SignInResult, error)
+	CreateChallenge(ctx *gin.Context, userID string) (MFAChallenge, error)
+	CompleteChallenge(ctx *gin.Context, req MFASignInRequest) (TokenPair, error)
+}
+
+type mfaService struct {
+	mfaRepository  repository.MFARepository
+	userRepository repository.UserRepository
+	tokenService   TokenService
+	lockoutService LockoutService
+	cfg            config.MFAConfig
+}
+
+func NewMFAService(mfaRepository repository.MFARepository,
+	userRepository repository.UserRepository,
+	tokenService TokenService,
+	lockoutService LockoutService,
+	cfg config.MFAConfig,
+) MFAService {
+	return &mfaService{
+		mfaRepository:  mfaRepository,
+		userRepository: userRepository,
+		tokenService:   tokenService,
+		lockoutService: lockoutService,
+		cfg:            cfg,
+	}
+}
+
+// EnrollTOTP generates a new secret for the authenticated user. It replaces
+// any unconfirmed secret and only takes effect once confirmed.
+func (s *mfaService) EnrollTOTP(ctx *gin.Context) (TOTPEnrollment, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	existing, err := s.mfaRepository.GetTOTPSecret(claims.UserID)
+	if err == nil && existing.ConfirmedAt != nil {
+		return TOTPEnrollment{}, ErrMFAAlreadyEnabled
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return TOTPEnrollment{}, err
+	}
+
+	user, err := s.userRepository.GetUserByID(claims.UserID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	err = s.mfaRepository.SaveTOTPSecret(models.TOTPSecret{UserID: user.ID, Secret: secret, CreatedAt: time.Now()})
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	account := user.Phone
+	if user.Email != nil && *user.Email != "" {
+		account = *user.Email
+	}
+
+	return TOTPEnrollment{Secret: secret, URI: utils.TOTPURI(s.cfg.Issuer, account, secret)}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their
+// app generates valid codes, and returns their recovery codes. These are
+// only ever shown here and by RegenerateRecoveryCodes. Every session is
+// signed out, so none started without the second factor can be refreshed.
+func (s *mfaService) ConfirmTOTP(ctx *gin.Context, code string) ([]string, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	secret, err := s.mfaRepository.GetTOTPSecret(claims.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := utils.ValidateTOTP(secret.Secret, code, time.Now(), s.cfg.Skew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, recoveryCodes, err := s.generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.mfaRepository.ConfirmTOTPSecret(claims.UserID, step, recoveryCodes)
+	if errors.Is(err, repository.ErrTOTPStepUsed) {
+		return nil, ErrInvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokenService.RevokeUserTokens(ctx, claims.UserID); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *mfaService) DisableTOTP(ctx *gin.Context, req MFACodeRequest) error {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	if err := s.verify(claims.UserID, req); err != nil {
+		return err
+	}
+
+	return s.mfaRepository.DeleteTOTPSecret(claims.UserID)
+}
+
+func (s *mfaService) RegenerateRecoveryCodes(ctx *gin.Context, req MFACodeRequest) ([]string, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	if err := s.verify(claims.UserID, req); err != nil {
+		return nil, err
+	}
+
+	codes, recoveryCodes, err := s.generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfaRepository.ReplaceRecoveryCodes(claims.UserID, recoveryCodes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *mfaService) IsEnabled(userID string) (bool, error) {
+	secret, err := s.mfaRepository.GetTOTPSecret(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return secret.ConfirmedAt != nil, nil
+}
+
+// SignIn finishes a sign in once the user has passed a first factor, be it
+// a password, an OTP or a magic link. Users with two-factor authentication
+// get a challenge to complete at /signin/mfa instead of tokens.
+func (s *mfaService) SignIn(ctx *gin.Context, userID string) (SignInResult, error) {
+	enabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	if enabled {
+		challenge, err := s.CreateChallenge(ctx, userID)
+		if err != nil {
+			return SignInResult{}, err
+		}
+		return SignInResult{Challenge: &challenge}, nil
+	}
+
+	tokens, err := s.tokenService.IssueTokens(ctx, userID, false)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	return SignInResult{Tokens: &tokens}, nil
+}
+
+// CreateChallenge issues the short-lived token a user who has passed the
+// first factor exchanges, together with a code, for real tokens.
+func (s *mfaService) CreateChallenge(ctx *gin.Context, userID string) (MFAChallenge, error) {
+	raw, err := generateMFAToken()
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+
+	_, err = s.mfaRepository.CreateChallenge(models.MFAChallenge{
+		UserID:    userID,
+		TokenHash: hashMFASecret(raw),
+		ExpiresAt: time.Now().Add(s.cfg.ChallengeTTL),
+	})
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+
+	return MFAChallenge{MFAToken: raw, ExpiresIn: int64(s.cfg.ChallengeTTL.Seconds())}, nil
+}
+
+// CompleteChallenge finishes a two-step sign in. Wrong codes count towards
+// the account lockout as well as the challenge's own attempt limit, so new
+// challenges can't be used to keep guessing.
+func (s *mfaService) CompleteChallenge(ctx *gin.Context, req MFASignInRequest) (TokenPair, error) {
+	challenge, err := s.mfaRepository.GetChallengeByHash(hashMFASecret(req.MFAToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TokenPair{}, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= s.cfg.MaxChallengeAttempts {
+		if err := s.mfaRepository.DeleteChallenge(challenge.ID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrInvalidMFAChallenge
+	}
+
+	if err := s.lockoutService.Check(models.ThrottleScopeAccount, challenge.UserID); err != nil {
+		return TokenPair{}, err
+	}
+
+	err = s.verify(challenge.UserID, req.MFACodeRequest)
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := s.mfaRepository.IncrementChallengeAttempts(challenge.ID); err != nil {
+			return TokenPair{}, err
+		}
+		if err := s.lockoutService.RecordFailure(models.ThrottleScopeAccount, challenge.UserID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, err
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.mfaRepository.DeleteChallenge(challenge.ID); err != nil {
+		return TokenPair{}, err
+	}
+	if err := s.lockoutService.Reset(models.ThrottleScopeAccount, challenge.UserID); err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.tokenService.IssueTokens(ctx, challenge.UserID, true)
+}
+
+// verify checks a TOTP code or consumes a recovery code for a user with
+// two-factor authentication enabled.
+func (s *mfaService) verify(userID string, req MFACodeRequest) error {
+	secret, err := s.mfaRepository.GetTOTPSecret(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if secret.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	if req.RecoveryCode != "" {
+		err := s.mfaRepository.UseRecoveryCode(userID, hashMFASecret(normalizeRecoveryCode(req.RecoveryCode)))
+		if errors.Is(err, repository.ErrRecoveryCodeNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+
+	step, ok := utils.ValidateTOTP(secret.Secret, req.Code, time.Now(), s.cfg.Skew)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	err = s.mfaRepository.UseTOTPStep(userID, step)
+	if errors.Is(err, repository.ErrTOTPStepUsed) {
+		return ErrInvalidMFACode
+	}
+
+	return err
+}
+
+// generateRecoveryCodes returns the codes to show the user and the hashed
+// records to store.
+func (s *mfaService) generateRecoveryCodes() ([]string, []models.RecoveryCode, error) {
+	codes := make([]string, 0, s.cfg.RecoveryCodes)
+	recoveryCodes := make([]models.RecoveryCode, 0, s.cfg.RecoveryCodes)
+
+	for i := 0; i < s.cfg.RecoveryCodes; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:5] + "-" + code[5:10]
+
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{CodeHash: hashMFASecret(normalizeRecoveryCode(code))})
+	}
+
+	return codes, recoveryCodes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+
+	return strings.ToLower(code)
+}
+
+func generateMFAToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashMFASecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+)
+
+var ErrUnsupportedOTPChannel = errors.New("OTP channel is not supported")
+
+// OTPSender delivers an OTP to a phone number over one channel. Every
+// SMSSender is an OTPSender.
+type OTPSender interface {
+	SendOTP(ctx context.Context, phone string, otp string) error
+}
+
+// OTPChannels holds the sender of every enabled phone channel and the order
+// they are tried in.
+type OTPChannels struct {
+	order   []string
+	senders map[string]OTPSender
+}
+
+// NewOTPChannels returns the channels listed in cfg.OTPChannels.Order, using
+// sms as the SMS channel.
+func NewOTPChannels(cfg *config.Config, sms SMSSender) (*OTPChannels, error) {
+	configured := cfg.OTPChannels.Order
+	if len(configured) == 0 {
+		configured = []string{models.OTPChannelSMS}
+	}
+
+	var order []string
+	senders := map[string]OTPSender{}
+	for _, channel := range configured {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		order = append(order, channel)
+
+		var sender OTPSender
+		var err error
+		switch channel {
+		case models.OTPChannelSMS:
+			sender = sms
+		case models.OTPChannelWhatsApp:
+			sender, err = newWhatsAppSender(cfg)
+		case models.OTPChannelVoice:
+			sender, err = newVoiceSender(cfg)
+		default:
+			err = fmt.Errorf("unknown OTP channel %q", channel)
+		}
+		if err != nil {
+			return nil, err
+		}
+		senders[channel] = sender
+	}
+
+	return NewOTPChannelsWith(order, senders), nil
+}
+
+// NewOTPChannelsWith returns channels using the given senders, tried in
+// order. Channels missing from senders are left out.
+func NewOTPChannelsWith(order []string, senders map[string]OTPSender) *OTPChannels {
+	channels := &OTPChannels{senders: map[string]OTPSender{}}
+	for _, channel := range order {
+		sender, ok := senders[channel]
+		if !ok {
+			continue
+		}
+		if _, seen := channels.senders[channel]; seen {
+			continue
+		}
+		channels.order = append(channels.order, channel)
+		channels.senders[channel] = sender
+	}
+
+	return channels
+}
+
+// Plan returns the channels to try, in order: preferred first if set, then
+// the configured order. The channel named by last, which delivered the
+// previous OTP, is moved to the end so a resend tries something else first.
+func (c *OTPChannels) Plan(preferred string, last string) ([]string, error) {
+	preferred = strings.ToLower(strings.TrimSpace(preferred))
+	if preferred != "" {
+		if _, ok := c.senders[preferred]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedOTPChannel, preferred)
+		}
+	}
+
+	plan := make([]string, 0, len(c.order))
+	if preferred != "" && preferred != last {
+		plan = append(plan, preferred)
+	}
+	for _, channel := range c.order {
+		if channel != preferred && channel != last {
+			plan = append(plan, channel)
+		}
+	}
+	if _, ok := c.senders[last]; ok {
+		plan = append(plan, last)
+	}
+
+	return plan, nil
+}
+
+func (c *OTPChannels) sender(channel string) (OTPSender, bool) {
+	sender, ok := c.senders[channel]
+	return sender, ok
+}
+
+func newWhatsAppSender(cfg *config.Config) (OTPSender, error) {
+	whatsAppConfig := cfg.OTPChannels.WhatsApp
+	smsConfig := cfg.SMS
+
+	switch strings.ToLower(whatsAppConfig.Provider) {
+	case "meta":
+		return NewWhatsAppCloudSender(whatsAppConfig.BaseURL, whatsAppConfig.PhoneNumberID, whatsAppConfig.AccessToken, whatsAppConfig.Template, whatsAppConfig.Language, smsConfig.Timeout), nil
+	case "twilio":
+		return NewTwilioWhatsAppSender(smsConfig.TwilioBaseURL, smsConfig.TwilioAccountSID, smsConfig.TwilioAuthToken, whatsAppConfig.From, smsConfig.Message, smsConfig.Timeout), nil
+	case "console":
+		return NewWriterOTPSender(os.Stdout, "WhatsApp", smsConfig.Message), nil
+	case "recording":
+		return NewRecordingSMSSender(), nil
+	case "":
+		return nil, errors.New("OTP channel whatsapp has no provider")
+	default:
+		return nil, fmt.Errorf("unknown WhatsApp provider %q", whatsAppConfig.Provider)
+	}
+}
+
+func newVoiceSender(cfg *config.Config) (OTPSender, error) {
+	voiceConfig := cfg.OTPChannels.Voice
+	smsConfig := cfg.SMS
+
+	switch strings.ToLower(voiceConfig.Provider) {
+	case "2factor":
+		apiKey := smsConfig.TwoFactorAPIKey
+		if apiKey == "" {
+			apiKey = cfg.SMS_API_KEY
+		}
+		return NewTwoFactorVoiceSender(apiKey, smsConfig.Timeout), nil
+	case "twilio":
+		return NewTwilioVoiceSender(smsConfig.TwilioBaseURL, smsConfig.TwilioAccountSID, smsConfig.TwilioAuthToken, voiceConfig.From, voiceConfig.Message, smsConfig.Timeout), nil
+	case "console":
+		return NewWriterOTPSender(os.Stdout, "Voice call", voiceConfig.Message), nil
+	case "recording":
+		return NewRecordingSMSSender(), nil
+	case "":
+		return nil, errors.New("OTP channel voice has no provider")
+	default:
+		return nil, fmt.Errorf("unknown voice provider %q", voiceConfig.Provider)
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+)
+
+// OTPRateLimiter decides whether another OTP may be sent to a recipient,
+// either a phone number or an email address, on behalf of a client IP.
+type OTPRateLimiter interface {
+	// Acquire records a send and returns nil, or returns a *ThrottledError
+	// and leaves no send recorded if a limit has been reached.
+	Acquire(recipient string, ip string) error
+}
+
+type otpRateLimiter struct {
+	store repository.OTPSendStore
+	cfg   config.OTPRateLimitConfig
+}
+
+func NewOTPRateLimiter(store repository.OTPSendStore, cfg config.OTPRateLimitConfig) OTPRateLimiter {
+	return &otpRateLimiter{store: store, cfg: cfg}
+}
+
+// otpSendWindow is the longest window a limit applies to. Older sends are
+// deleted by the OTPSweeper.
+const otpSendWindow = 24 * time.Hour
+
+type rateLimit struct {
+	limit  int
+	window time.Duration
+}
+
+// Acquire records the send first and takes it back if a limit was reached,
+// only counting sends recorded before it. Of two concurrent requests, the
+// one recorded later sees the other, so they can't both use the last send.
+func (l *otpRateLimiter) Acquire(recipient string, ip string) error {
+	now := time.Now()
+	keys := []string{"to:" + recipient}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+
+	ids := make([]uint, 0, len(keys))
+	for _, key := range keys {
+		id, err := l.store.RecordSend(key, now)
+		if err != nil {
+			l.release(ids)
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := l.check(keys, ids, now); err != nil {
+		l.release(ids)
+		return err
+	}
+
+	return nil
+}
+
+// check returns a *ThrottledError if the sends recorded for keys before ids
+// leave no room for another one. The first key is the recipient's.
+func (l *otpRateLimiter) check(keys []string, ids []uint, now time.Time) error {
+	since := now.Add(-otpSendWindow)
+
+	recipientSends, err := l.store.GetSends(keys[0], since, ids[0])
+	if err != nil {
+		return err
+	}
+	if n := len(recipientSends); n > 0 && l.cfg.ResendCooldown > 0 {
+		if next := recipientSends[n-1].Add(l.cfg.ResendCooldown); next.After(now) {
+			return &ThrottledError{RetryAfter: next.Sub(now)}
+		}
+	}
+
+	retryAfter := retryAfterLimits(recipientSends, now,
+		rateLimit{l.cfg.PhonePerHour, time.Hour},
+		rateLimit{l.cfg.PhonePerDay, 24 * time.Hour},
+	)
+
+	if len(keys) > 1 {
+		ipSends, err := l.store.GetSends(keys[1], since, ids[1])
+		if err != nil {
+			return err
+		}
+		ipRetryAfter := retryAfterLimits(ipSends, now,
+			rateLimit{l.cfg.IPPerHour, time.Hour},
+			rateLimit{l.cfg.IPPerDay, 24 * time.Hour},
+		)
+		if ipRetryAfter > retryAfter {
+			retryAfter = ipRetryAfter
+		}
+	}
+
+	if retryAfter > 0 {
+		return &ThrottledError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// release takes back sends recorded by Acquire.
+func (l *otpRateLimiter) release(ids []uint) {
+	for _, id := range ids {
+		if err := l.store.DeleteSend(id); err != nil {
+			log.Printf("failed to delete OTP send %d: %v", id, err)
+		}
+	}
+}
+
+// retryAfterLimits returns how long until sends, ordered oldest first, are
+// back under every limit, or 0 if they already are.
+func retryAfterLimits(sends []time.Time, now time.Time, limits ...rateLimit) time.Duration {
+	var retryAfter time.Duration
+	for _, limit := range limits {
+		if limit.limit <= 0 {
+			continue
+		}
+
+		since := now.Add(-limit.window)
+		var inWindow []time.Time
+		for _, at := range sends {
+			if !at.Before(since) {
+				inWindow = append(inWindow, at)
+			}
+		}
+		if len(inWindow) < limit.limit {
+			continue
+		}
+
+		// There is room for one more send once all but limit-1 of these have
+		// left the window.
+		wait := inWindow[len(inWindow)-limit.limit].Add(limit.window).Sub(now)
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	return retryAfter
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+)
+
+func TestOTPRateLimiterWindows(t *testing.T) {
+	limits := config.OTPRateLimitConfig{
+		ResendCooldown: 30 * time.Second,
+		PhonePerHour:   3,
+		PhonePerDay:    5,
+		IPPerHour:      4,
+		IPPerDay:       10,
+	}
+
+	tests := []struct {
+		name string
+		// earlier are the sends already recorded, by key, as offsets from now.
+		earlier        map[string][]time.Duration
+		ip             string
+		wantRetryAfter time.Duration
+	}{
+		{
+			name: "first send",
+		},
+		{
+			name:           "within the resend cooldown",
+			earlier:        map[string][]time.Duration{"to:+919876543210": {-10 * time.Second}},
+			wantRetryAfter: 20 * time.Second,
+		},
+		{
+			name:    "after the resend cooldown",
+			earlier: map[string][]time.Duration{"to:+919876543210": {-time.Minute}},
+		},
+		{
+			name:           "hourly limit reached",
+			earlier:        map[string][]time.Duration{"to:+919876543210": {-50 * time.Minute, -40 * time.Minute, -10 * time.Minute}},
+			wantRetryAfter: 10 * time.Minute,
+		},
+		{
+			name:    "hourly sends have left the window",
+			earlier: map[string][]time.Duration{"to:+919876543210": {-3 * time.Hour, -2 * time.Hour, -90 * time.Minute}},
+		},
+		{
+			name:           "daily limit reached",
+			earlier:        map[string][]time.Duration{"to:+919876543210": {-20 * time.Hour, -10 * time.Hour, -5 * time.Hour, -4 * time.Hour, -3 * time.Hour}},
+			wantRetryAfter: 4 * time.Hour,
+		},
+		{
+			name:    "sends older than a day are ignored",
+			earlier: map[string][]time.Duration{"to:+919876543210": {-30 * time.Hour, -29 * time.Hour, -28 * time.Hour, -27 * time.Hour, -26 * time.Hour}},
+		},
+		{
+			name:           "ip hourly limit reached across recipients",
+			earlier:        map[string][]time.Duration{"ip:203.0.113.7": {-55 * time.Minute, -30 * time.Minute, -20 * time.Minute, -10 * time.Minute}},
+			ip:             "203.0.113.7",
+			wantRetryAfter: 5 * time.Minute,
+		},
+		{
+			name:    "ip limits don't apply without an ip",
+			earlier: map[string][]time.Duration{"ip:203.0.113.7": {-55 * time.Minute, -30 * time.Minute, -20 * time.Minute, -10 * time.Minute}},
+		},
+		{
+			name: "the longer wait wins",
+			earlier: map[string][]time.Duration{
+				"to:+919876543210": {-50 * time.Minute, -40 * time.Minute, -10 * time.Minute},
+				"ip:203.0.113.7":   {-35 * time.Minute, -30 * time.Minute, -20 * time.Minute, -15 * time.Minute},
+			},
+			ip:             "203.0.113.7",
+			wantRetryAfter: 25 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemoryOTPSendStore()
+			now := time.Now()
+			for key, offsets := range tt.earlier {
+				for _, offset := range offsets {
+					if _, err := store.RecordSend(key, now.Add(offset)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			limiter := NewOTPRateLimiter(store, limits)
+
+			err := limiter.Acquire("+919876543210", tt.ip)
+
+			var throttled *ThrottledError
+			if tt.wantRetryAfter == 0 {
+				if err != nil {
+					t.Fatalf("Acquire() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, &throttled) {
+				t.Fatalf("Acquire() = %v, want a *ThrottledError", err)
+			}
+			if diff := throttled.RetryAfter - tt.wantRetryAfter; diff > time.Second || diff < -time.Second {
+				t.Errorf("RetryAfter = %v, want about %v", throttled.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestOTPRateLimiterRecordsOnlyAllowedSends(t *testing.T) {
+	store := repository.NewMemoryOTPSendStore()
+	limiter := NewOTPRateLimiter(store, config.OTPRateLimitConfig{PhonePerHour: 2, IPPerHour: 100})
+
+	for i := 0; i < 5; i++ {
+		err := limiter.Acquire("+919876543210", "203.0.113.7")
+		if wantThrottled := i >= 2; (err != nil) != wantThrottled {
+			t.Fatalf("send %d: Acquire() = %v", i+1, err)
+		}
+	}
+
+	since := time.Now().Add(-time.Hour)
+	for _, key := range []string{"to:+919876543210", "ip:203.0.113.7"} {
+		sends, err := store.GetSends(key, since, ^uint(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sends) != 2 {
+			t.Errorf("%s has %d sends recorded, want 2", key, len(sends))
+		}
+	}
+}
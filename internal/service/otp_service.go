@@ -1,152 +1,405 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"strconv"
+	"log"
+	"math/big"
+	"strings"
 	"time"
 
-	"net/http"
-
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/tanush-128/openzo_backend/user/config"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/repository"
 	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidOTP          = errors.New("invalid OTP")
+	ErrOTPExpired          = errors.New("OTP has expired")
+	ErrOTPPurposeMismatch  = errors.New("OTP was not issued for this purpose")
+	ErrOTPDeliveryFailed   = errors.New("failed to send OTP")
+	ErrOTPTooManyAttempts  = errors.New("too many incorrect attempts, request a new OTP")
+	ErrPhoneChangeRequired = errors.New("phone number is not registered, use the phone change flow to add it to an account")
 )
 
+// OTPDelivery identifies a sent OTP and the channel that delivered it.
+type OTPDelivery struct {
+	VerificationId string `json:"verification_id"`
+	Channel        string `json:"channel"`
+}
+
 type OTPService interface {
-	GenerateOTP(ctx *gin.Context, phoneNo string) (string, error)
-	VerifyOTP(ctx *gin.Context, phone string, verificationId string, otp string, userId string) (string, error)
-	SendOTP(phoneNo string, otp string)
+	GenerateOTP(ctx *gin.Context, phoneNo string, channel string, resend bool) (OTPDelivery, error)
+	GenerateOTPForPurpose(ctx *gin.Context, phoneNo string, purpose string, userId string) (string, error)
+	GenerateDecoyOTP(ctx *gin.Context, phoneNo string) (string, error)
+	VerifyOTP(ctx *gin.Context, phone string, verificationId string, otp string, userId string) (SignInResult, error)
+	CheckOTP(ctx *gin.Context, phone string, verificationId string, otp string, purpose string, userId string) error
+	GenerateOTPInBackground(ctx *gin.Context, phoneNo string, purpose string, userId string) (string, error)
+	SendOTP(ctx context.Context, phoneNo string, otp string, channels []string) (string, error)
+
+	GenerateEmailOTP(ctx *gin.Context, email string) (string, error)
+	VerifyEmailOTP(ctx *gin.Context, email string, verificationId string, otp string) (SignInResult, error)
+	SendMagicLink(ctx *gin.Context, email string) error
+	VerifyMagicLink(ctx *gin.Context, verificationId string, token string) (SignInResult, error)
+
+	RequestEmailChange(ctx context.Context, userId string, email string) error
+	ConfirmEmailChange(ctx *gin.Context, verificationId string, token string) (models.User, error)
 }
 
 type otpService struct {
 	otpRepository  repository.OTPRepository
 	userRepository repository.UserRepository
+	mfaService     MFAService
+	channels       *OTPChannels
+	mailer         Mailer
+	rateLimiter    OTPRateLimiter
 	cfg            *config.Config
 }
 
 func NewOTPService(otpRepository repository.OTPRepository,
 	userRepository repository.UserRepository,
+	mfaService MFAService,
+	channels *OTPChannels,
+	mailer Mailer,
+	rateLimiter OTPRateLimiter,
 	cfg *config.Config,
 ) OTPService {
-	return &otpService{otpRepository: otpRepository, userRepository: userRepository, cfg: cfg}
+	return &otpService{
+		otpRepository:  otpRepository,
+		userRepository: userRepository,
+		mfaService:     mfaService,
+		channels:       channels,
+		mailer:         mailer,
+		rateLimiter:    rateLimiter,
+		cfg:            cfg,
+	}
 }
 
-func (s *otpService) GenerateOTP(ctx *gin.Context, phoneNo string) (string, error) {
-	var otp models.OTP
-	otp.Phone = phoneNo
+// GenerateOTP sends a sign in OTP to phoneNo, over channel if it is set and
+// otherwise over the first configured channel that works. A resend tries the
+// channel that delivered the previous OTP last.
+func (s *otpService) GenerateOTP(ctx *gin.Context, phoneNo string, channel string, resend bool) (OTPDelivery, error) {
+	phoneNo, err := phonenumber.Normalize(phoneNo, s.cfg.Phone.DefaultRegion)
+	if err != nil {
+		return OTPDelivery{}, err
+	}
 
-	otp_number := generatedRandomOTP()
-	fmt.Println(otp_number)
-	go s.SendOTP(phoneNo, strconv.Itoa(otp_number))
-	otp.HashedOTP = utils.HashNumberWithSecret(otp_number, "secret")
+	var last string
+	if resend {
+		previous, err := s.otpRepository.GetLatestOTP(phoneNo, models.OTPPurposeLogin)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return OTPDelivery{}, err
+		}
+		last = previous.Channel
+	}
 
-	generatedOTP, err := s.otpRepository.CreateOTP(otp)
+	channels, err := s.channels.Plan(channel, last)
+	if err != nil {
+		return OTPDelivery{}, err
+	}
+
+	return s.generateOTP(ctx, phoneNo, models.OTPPurposeLogin, "", channels)
+}
+
+// GenerateOTPForPurpose sends a new OTP to phoneNo on behalf of the user
+// with id userId, which CheckOTP then requires. Sends are rate limited per
+// phone number and client IP; over the limit a *ThrottledError is returned.
+func (s *otpService) GenerateOTPForPurpose(ctx *gin.Context, phoneNo string, purpose string, userId string) (string, error) {
+	phoneNo, err := phonenumber.Normalize(phoneNo, s.cfg.Phone.DefaultRegion)
 	if err != nil {
 		return "", err
 	}
 
-	return generatedOTP.ID, nil
+	channels, err := s.channels.Plan("", "")
+	if err != nil {
+		return "", err
+	}
+
+	delivery, err := s.generateOTP(ctx, phoneNo, purpose, userId, channels)
+	if err != nil {
+		return "", err
+	}
+
+	return delivery.VerificationId, nil
 }
 
-func generatedRandomOTP() int {
-	random := 1000 + rand.Intn(9000)
+// GenerateDecoyOTP counts against the same rate limits as a real OTP and
+// returns a verification id that looks real, but sends and stores nothing.
+// It answers requests for unregistered numbers, so the response doesn't
+// reveal whether a number has an account.
+func (s *otpService) GenerateDecoyOTP(ctx *gin.Context, phoneNo string) (string, error) {
+	if err := s.rateLimiter.Acquire(phoneNo, ctx.ClientIP()); err != nil {
+		return "", err
+	}
 
-	return random
-	// return strconv.Itoa(random)
+	return uuid.New().String(), nil
 }
 
-func (s *otpService) VerifyOTP(ctx *gin.Context, phone string, verificationId string, otp string, userId string) (string, error) {
-	_otp, err := s.otpRepository.GetOTPByID(verificationId)
+func (s *otpService) generateOTP(ctx *gin.Context, phoneNo string, purpose string, userId string, channels []string) (OTPDelivery, error) {
+	otp, code, review, err := s.newOTP(ctx, phoneNo, purpose, userId)
+	if err != nil {
+		return OTPDelivery{}, err
+	}
+
+	if review {
+		if len(channels) > 0 {
+			otp.Channel = channels[0]
+		}
+	} else {
+		// An OTP the user never received can't be verified, so it is only
+		// stored once a channel has delivered it.
+		otp.Channel, err = s.SendOTP(ctx, phoneNo, code, channels)
+		if err != nil {
+			return OTPDelivery{}, err
+		}
+	}
+
+	generatedOTP, err := s.otpRepository.CreateOTP(otp)
+	if err != nil {
+		return OTPDelivery{}, err
+	}
+
+	return OTPDelivery{VerificationId: generatedOTP.ID, Channel: generatedOTP.Channel}, nil
+}
+
+// GenerateOTPInBackground is GenerateOTPForPurpose, except that the OTP is
+// stored first and sent after the verification id is returned. The response
+// then doesn't wait on the SMS provider, so it takes as long as
+// GenerateDecoyOTP's and doesn't reveal whether the number has an account.
+// An OTP no channel delivers is deleted.
+func (s *otpService) GenerateOTPInBackground(ctx *gin.Context, phoneNo string, purpose string, userId string) (string, error) {
+	phoneNo, err := phonenumber.Normalize(phoneNo, s.cfg.Phone.DefaultRegion)
 	if err != nil {
 		return "", err
 	}
 
-	if _otp.CreatedAt.Add(5 * time.Minute).Before(time.Now()) {
-		return "", errors.New("OTP has expired")
+	channels, err := s.channels.Plan("", "")
+	if err != nil {
+		return "", err
 	}
 
-	otp_number, _ := strconv.Atoi(otp)
+	otp, code, review, err := s.newOTP(ctx, phoneNo, purpose, userId)
+	if err != nil {
+		return "", err
+	}
 
-	if _otp.Phone != phone {
-		return "", errors.New("invalid phone number")
+	generatedOTP, err := s.otpRepository.CreateOTP(otp)
+	if err != nil {
+		return "", err
 	}
 
-	if _otp.HashedOTP != utils.HashNumberWithSecret(otp_number, "secret") {
-		return "", errors.New("invalid OTP")
+	if !review {
+		go s.deliverOTP(generatedOTP.ID, phoneNo, code, channels)
 	}
 
-	// Delete the OTP from the database
-	s.otpRepository.DeleteOTP(verificationId)
+	return generatedOTP.ID, nil
+}
 
-	user, err := s.userRepository.GetUserByMobile(phone)
-	if err != nil || user.ID == "" {
-
-		if userId != "" {
-			user, err = s.userRepository.GetUserByID(userId)
-			if err != nil {
-				return "", err
-			}
-		} else {
-
-			var newUser models.User
-			newUser.Phone = phone
-			newUser.CreatedAt = time.Now()
-			createdUser, err := s.userRepository.CreateUser(newUser)
-			if err != nil {
-				return "", err
-			}
-
-			user = createdUser
+// deliverOTP sends the stored OTP with the given id, deleting it if no
+// channel delivers it.
+func (s *otpService) deliverOTP(id string, phoneNo string, code string, channels []string) {
+	if _, err := s.SendOTP(context.Background(), phoneNo, code, channels); err != nil {
+		log.Printf("failed to deliver OTP %s: %v", id, err)
+		if err := s.otpRepository.DeleteOTP(id); err != nil {
+			log.Printf("failed to delete undelivered OTP %s: %v", id, err)
 		}
+	}
+}
 
+// newOTP counts a send to phoneNo against the rate limits and returns the
+// OTP to store and its code. Review phones signing in get the fixed review
+// code, and nothing should be sent to them.
+func (s *otpService) newOTP(ctx *gin.Context, phoneNo string, purpose string, userId string) (models.OTP, string, bool, error) {
+	if err := s.rateLimiter.Acquire(phoneNo, ctx.ClientIP()); err != nil {
+		return models.OTP{}, "", false, err
 	}
-	// else if userId != "" && user.ID != userId {
-	// 	return "", errors.New("phone number already exists")
-	// }
-	user.IsVerified = true
-	user.Phone = phone
 
-	_, err = s.userRepository.UpdateUser(user)
+	purposeConfig := s.cfg.OTP.ForPurpose(purpose)
+
+	var otp models.OTP
+	otp.Phone = phoneNo
+	otp.Purpose = purpose
+	otp.UserID = userId
+	otp.ExpiresAt = time.Now().Add(purposeConfig.TTL)
+
+	code, review := s.cfg.OTP.ReviewCodeFor(phoneNo, purpose)
+	if !review {
+		var err error
+		code, err = generatedRandomOTP(purposeConfig.Length)
+		if err != nil {
+			return models.OTP{}, "", false, err
+		}
+	}
+	otp.HashedOTP = utils.HashOTPWithSecret(code, s.cfg.OTP.Secret)
+
+	return otp, code, review, nil
+}
+
+// generatedRandomOTP returns a random code of length digits, which may start
+// with zeros.
+func generatedRandomOTP(length int) (string, error) {
+	if length <= 0 {
+		length = 4
+	}
+
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	random, err := rand.Int(rand.Reader, max)
 	if err != nil {
 		return "", err
 	}
 
-	token, err := CreateJwtToken(user.ID)
+	code := random.String()
+
+	return strings.Repeat("0", length-len(code)) + code, nil
+}
+
+// CheckOTP verifies an OTP issued for purpose and userId, empty for sign in
+// OTPs, and consumes it. Every check counts as an attempt, and once the
+// configured number of attempts is used up the OTP is deleted.
+func (s *otpService) CheckOTP(ctx *gin.Context, phone string, verificationId string, otp string, purpose string, userId string) error {
+	phone, err := phonenumber.Normalize(phone, s.cfg.Phone.DefaultRegion)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return token, nil
+	_, err = s.checkOTP(verificationId, otp, purpose, func(_otp models.OTP) error {
+		if _otp.Phone != phone || _otp.UserID != userId {
+			return ErrInvalidOTP
+		}
+		return nil
+	})
+
+	return err
 }
 
-func (s *otpService) SendOTP(phoneNo string, otp string) {
-	// url := "https://2factor.in/API/V1/fae85dd6-50a7-11ef-8b60-0200cd936042/SMS/+919999999999/12345/OTP1"
-	url := "https://2factor.in/API/V1/" + s.cfg.SMS_API_KEY + "/SMS/" + phoneNo + "/" + otp + "/OTP 1"
-	method := "GET"
+// checkOTP holds the checks shared by every channel. recipient rejects an
+// OTP that wasn't sent to the caller's phone number or email address.
+func (s *otpService) checkOTP(verificationId string, otp string, purpose string, recipient func(models.OTP) error) (models.OTP, error) {
+	_otp, err := s.otpRepository.GetOTPByID(verificationId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.OTP{}, ErrInvalidOTP
+	}
+	if err != nil {
+		return models.OTP{}, err
+	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+	expiresAt := _otp.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = _otp.CreatedAt.Add(s.cfg.OTP.ForPurpose(_otp.Purpose).TTL)
+	}
+	if expiresAt.Before(time.Now()) {
+		return models.OTP{}, ErrOTPExpired
+	}
 
+	err = s.otpRepository.UseOTPAttempt(verificationId, s.cfg.OTP.MaxAttempts)
+	if errors.Is(err, repository.ErrOTPAttemptsExhausted) {
+		s.otpRepository.DeleteOTP(verificationId)
+		return models.OTP{}, ErrOTPTooManyAttempts
+	}
 	if err != nil {
-		fmt.Println(err)
-		return
+		return models.OTP{}, err
+	}
+
+	if err := recipient(_otp); err != nil {
+		return models.OTP{}, err
+	}
+
+	if _otp.Purpose != purpose {
+		return models.OTP{}, ErrOTPPurposeMismatch
+	}
+
+	hashedOTP := utils.HashOTPWithSecret(otp, s.cfg.OTP.Secret)
+	if subtle.ConstantTimeCompare([]byte(_otp.HashedOTP), []byte(hashedOTP)) != 1 {
+		if _otp.Attempts+1 >= s.cfg.OTP.MaxAttempts {
+			s.otpRepository.DeleteOTP(verificationId)
+			return models.OTP{}, ErrOTPTooManyAttempts
+		}
+		return models.OTP{}, ErrInvalidOTP
 	}
-	res, err := client.Do(req)
+
+	// Only one of several concurrent verifications can delete the OTP, and
+	// only that one succeeds.
+	err = s.otpRepository.UseOTP(verificationId)
+	if errors.Is(err, repository.ErrOTPUsed) {
+		return models.OTP{}, ErrInvalidOTP
+	}
+	if err != nil {
+		return models.OTP{}, err
+	}
+
+	return _otp, nil
+}
+
+func (s *otpService) VerifyOTP(ctx *gin.Context, phone string, verificationId string, otp string, userId string) (SignInResult, error) {
+	phone, err := phonenumber.Normalize(phone, s.cfg.Phone.DefaultRegion)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return SignInResult{}, err
+	}
+
+	user, err := s.userRepository.GetUserByMobile(phone)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return SignInResult{}, err
+	}
+
+	if err := s.CheckOTP(ctx, phone, verificationId, otp, models.OTPPurposeLogin, ""); err != nil {
+		return SignInResult{}, err
+	}
+
+	// Signing in can't attach a new number to an existing account; that
+	// takes the authenticated phone change flow. This is only checked once
+	// the OTP is, so it doesn't tell anyone whether a number is registered.
+	if user.ID == "" && userId != "" {
+		return SignInResult{}, ErrPhoneChangeRequired
+	}
+
+	if user.ID == "" {
+		var newUser models.User
+		newUser.Phone = phone
+		newUser.CreatedAt = time.Now()
+		createdUser, err := s.userRepository.CreateUser(newUser)
+		if err != nil {
+			return SignInResult{}, err
+		}
+
+		user = createdUser
 	}
-	defer res.Body.Close()
+	user.IsVerified = true
+	user.Phone = phone
 
-	body, err := ioutil.ReadAll(res.Body)
+	_, err = s.userRepository.UpdateUser(user)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return SignInResult{}, err
 	}
-	fmt.Println(string(body))
+
+	return s.mfaService.SignIn(ctx, user.ID)
+}
+
+// SendOTP tries each of channels in turn until one delivers the OTP, and
+// returns that channel.
+func (s *otpService) SendOTP(ctx context.Context, phoneNo string, otp string, channels []string) (string, error) {
+	err := fmt.Errorf("%w: no OTP channel available", ErrOTPDeliveryFailed)
+	for _, channel := range channels {
+		sender, ok := s.channels.sender(channel)
+		if !ok {
+			continue
+		}
+
+		sendErr := sender.SendOTP(ctx, phoneNo, otp)
+		if sendErr == nil {
+			return channel, nil
+		}
+
+		log.Printf("failed to send OTP to %s over %s: %v", phoneNo, channel, sendErr)
+		err = fmt.Errorf("%w: %s: %v", ErrOTPDeliveryFailed, channel, sendErr)
+	}
+
+	return "", err
 }
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+type testOTPService struct {
+	OTPService
+	sms            *RecordingSMSSender
+	whatsapp       *RecordingSMSSender
+	mailer         *RecordingMailer
+	userRepository repository.UserRepository
+	otpRepository  repository.OTPRepository
+}
+
+func newTestOTPService(t *testing.T, cfg *config.Config) testOTPService {
+	t.Helper()
+
+	db := newTestDB(t, &models.User{}, &models.Role{}, &models.OTP{})
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	cfg.Phone.DefaultRegion = "IN"
+	cfg.OTP.Secret = "test-otp-secret"
+	cfg.OTP.MaxAttempts = 5
+	cfg.OTP.Login = config.OTPPurposeConfig{Length: 4, TTL: 5 * time.Minute}
+	cfg.OTP.PhoneChange = config.OTPPurposeConfig{Length: 6, TTL: 10 * time.Minute}
+	cfg.OTP.PasswordReset = config.OTPPurposeConfig{Length: 6, TTL: 10 * time.Minute}
+	cfg.OTP.EmailLogin = config.OTPPurposeConfig{Length: 6, TTL: 10 * time.Minute}
+	cfg.OTP.MagicLink = config.OTPPurposeConfig{TTL: 15 * time.Minute}
+	cfg.Mail.MagicLinkURL = "https://openzo.example/email/magic-link/verify"
+
+	sms := NewRecordingSMSSender()
+	whatsapp := NewRecordingSMSSender()
+	channels := NewOTPChannelsWith([]string{"sms", "whatsapp"}, map[string]OTPSender{"sms": sms, "whatsapp": whatsapp})
+
+	userRepository := repository.NewUserRepository(db)
+	otpRepository := repository.NewOTPRepository(db)
+	mailer := NewRecordingMailer()
+	rateLimiter := NewOTPRateLimiter(repository.NewMemoryOTPSendStore(), cfg.OTPRateLimit)
+	otpService := NewOTPService(otpRepository, userRepository, nil, channels, mailer, rateLimiter, cfg)
+
+	return testOTPService{OTPService: otpService, sms: sms, whatsapp: whatsapp, mailer: mailer, userRepository: userRepository, otpRepository: otpRepository}
+}
+
+func TestGenerateOTPSendsTheStoredCode(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	ctx := newTestContext(nil)
+
+	delivery, err := s.GenerateOTP(ctx, "98765 43210", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Channel != "sms" {
+		t.Errorf("delivered over %q, want sms", delivery.Channel)
+	}
+
+	code, ok := s.sms.Last("+919876543210")
+	if !ok {
+		t.Fatal("no SMS was sent to the normalized number")
+	}
+	if len(code) != 4 {
+		t.Errorf("code %q has %d digits, want 4", code, len(code))
+	}
+	if err := s.CheckOTP(ctx, "+919876543210", delivery.VerificationId, code, models.OTPPurposeLogin, ""); err != nil {
+		t.Fatalf("CheckOTP with the sent code: %v", err)
+	}
+}
+
+func TestGenerateOTPFallsBackToTheNextChannel(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	s.sms.Err = errors.New("provider is down")
+	ctx := newTestContext(nil)
+
+	delivery, err := s.GenerateOTP(ctx, "+919876543210", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Channel != "whatsapp" {
+		t.Errorf("delivered over %q, want whatsapp", delivery.Channel)
+	}
+	if _, ok := s.whatsapp.Last("+919876543210"); !ok {
+		t.Error("no WhatsApp message was sent")
+	}
+
+	s.whatsapp.Err = errors.New("provider is down")
+	if _, err := s.GenerateOTP(ctx, "+919876543210", "", false); !errors.Is(err, ErrOTPDeliveryFailed) {
+		t.Fatalf("GenerateOTP with every channel down = %v, want %v", err, ErrOTPDeliveryFailed)
+	}
+}
+
+// blockingSender holds every send until release is closed.
+type blockingSender struct {
+	release chan struct{}
+	*RecordingSMSSender
+}
+
+func (s blockingSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	<-s.release
+	return s.RecordingSMSSender.SendOTP(ctx, phone, otp)
+}
+
+func TestGenerateOTPInBackgroundDoesNotWaitForDelivery(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	sender := blockingSender{release: make(chan struct{}), RecordingSMSSender: s.sms}
+	s.OTPService.(*otpService).channels = NewOTPChannelsWith([]string{"sms"}, map[string]OTPSender{"sms": sender})
+	ctx := newTestContext(nil)
+
+	done := make(chan string)
+	go func() {
+		id, err := s.GenerateOTPInBackground(ctx, "+919876543210", models.OTPPurposePasswordReset, "user-1")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- id
+	}()
+
+	var id string
+	select {
+	case id = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GenerateOTPInBackground waited for the SMS provider")
+	}
+
+	close(sender.release)
+	code := waitForSMS(t, s.sms, "+919876543210")
+	if err := s.CheckOTP(ctx, "+919876543210", id, code, models.OTPPurposePasswordReset, "user-1"); err != nil {
+		t.Fatalf("CheckOTP with the delivered code: %v", err)
+	}
+}
+
+func TestGenerateOTPInBackgroundDeletesUndeliveredOTPs(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	s.sms.Err = errors.New("provider is down")
+	s.whatsapp.Err = errors.New("provider is down")
+
+	id, err := s.GenerateOTPInBackground(newTestContext(nil), "+919876543210", models.OTPPurposePasswordReset, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.otpRepository.GetOTPByID(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("undelivered OTP is still stored: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForSMS(t *testing.T, sms *RecordingSMSSender, phone string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if code, ok := sms.Last(phone); ok {
+			return code
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no SMS was sent to %s", phone)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReviewCodeOnlySignsIn(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.OTP.ReviewPhones = []string{"+919999999999"}
+	cfg.OTP.ReviewCode = "1234"
+	s := newTestOTPService(t, cfg)
+	ctx := newTestContext(nil)
+
+	delivery, err := s.GenerateOTP(ctx, "+919999999999", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sms.Sent()) != 0 {
+		t.Error("an SMS was sent to the review phone")
+	}
+	if err := s.CheckOTP(ctx, "+919999999999", delivery.VerificationId, "1234", models.OTPPurposeLogin, ""); err != nil {
+		t.Fatalf("review code for login: %v", err)
+	}
+
+	for _, purpose := range []string{models.OTPPurposePasswordReset, models.OTPPurposePhoneChange} {
+		id, err := s.GenerateOTPForPurpose(ctx, "+919999999999", purpose, "review-user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CheckOTP(ctx, "+919999999999", id, "1234", purpose, "review-user"); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("review code for %s = %v, want %v", purpose, err, ErrInvalidOTP)
+		}
+		code, ok := s.sms.Last("+919999999999")
+		if !ok {
+			t.Fatalf("no %s code was sent to the review phone", purpose)
+		}
+		if err := s.CheckOTP(ctx, "+919999999999", id, code, purpose, "review-user"); err != nil {
+			t.Errorf("sent %s code: %v", purpose, err)
+		}
+	}
+}
+
+func TestVerifyOTPChecksTheCodeBeforeRejectingUnregisteredNumbers(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	ctx := newTestContext(nil)
+
+	delivery, err := s.GenerateOTP(ctx, "+919876543210", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := s.sms.Last("+919876543210")
+	wrong := "0000"
+	if code == wrong {
+		wrong = "1111"
+	}
+
+	if _, err := s.VerifyOTP(ctx, "+919876543210", delivery.VerificationId, wrong, "user-1"); !errors.Is(err, ErrInvalidOTP) {
+		t.Errorf("VerifyOTP() with a wrong code = %v, want %v", err, ErrInvalidOTP)
+	}
+	if _, err := s.VerifyOTP(ctx, "+919876543210", delivery.VerificationId, code, "user-1"); !errors.Is(err, ErrPhoneChangeRequired) {
+		t.Errorf("VerifyOTP() with the sent code = %v, want %v", err, ErrPhoneChangeRequired)
+	}
+}
+
+// racingOTPRepository holds every verification with the right code until
+// all of them have checked it, so they race to use the OTP.
+type racingOTPRepository struct {
+	repository.OTPRepository
+	checked *sync.WaitGroup
+}
+
+func (r racingOTPRepository) UseOTP(id string) error {
+	r.checked.Done()
+	r.checked.Wait()
+	return r.OTPRepository.UseOTP(id)
+}
+
+func TestCheckOTPSucceedsOnceUnderConcurrentVerifies(t *testing.T) {
+	s := newTestOTPService(t, nil)
+	ctx := newTestContext(nil)
+
+	delivery, err := s.GenerateOTP(ctx, "+919876543210", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := s.sms.Last("+919876543210")
+
+	// As many verifies as there are attempts, so none is turned away for
+	// running out of them.
+	const verifies = 5
+	checked := &sync.WaitGroup{}
+	checked.Add(verifies)
+	s.OTPService.(*otpService).otpRepository = racingOTPRepository{OTPRepository: s.otpRepository, checked: checked}
+
+	errs := make(chan error, verifies)
+	var wg sync.WaitGroup
+	for i := 0; i < verifies; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.CheckOTP(newTestContext(nil), "+919876543210", delivery.VerificationId, code, models.OTPPurposeLogin, "")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrInvalidOTP):
+			t.Errorf("CheckOTP() = %v, want nil or %v", err, ErrInvalidOTP)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d verifies succeeded, want 1", succeeded)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+)
+
+var (
+	otpSweeperPurgedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otp_sweeper_rows_purged_total",
+			Help: "Total number of expired OTP rows deleted by the sweeper",
+		},
+	)
+	mfaChallengeSweeperPurgedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mfa_challenge_sweeper_rows_purged_total",
+			Help: "Total number of expired MFA challenge rows deleted by the sweeper",
+		},
+	)
+	refreshTokenSweeperPurgedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "refresh_token_sweeper_rows_purged_total",
+			Help: "Total number of expired refresh token rows deleted by the sweeper",
+		},
+	)
+	revokedTokenSweeperPurgedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "revoked_token_sweeper_rows_purged_total",
+			Help: "Total number of expired revoked token rows deleted by the sweeper",
+		},
+	)
+	otpSendSweeperPurgedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otp_send_sweeper_rows_purged_total",
+			Help: "Total number of OTP send rows past the rate limit window deleted by the sweeper",
+		},
+	)
+	otpSweeperErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otp_sweeper_errors_total",
+			Help: "Total number of failed OTP sweeps",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(otpSweeperPurgedTotal)
+	prometheus.MustRegister(mfaChallengeSweeperPurgedTotal)
+	prometheus.MustRegister(refreshTokenSweeperPurgedTotal)
+	prometheus.MustRegister(revokedTokenSweeperPurgedTotal)
+	prometheus.MustRegister(otpSendSweeperPurgedTotal)
+	prometheus.MustRegister(otpSweeperErrorsTotal)
+}
+
+// OTPSweeper periodically deletes expired OTPs and MFA challenges, which are
+// otherwise only removed when they are used, refresh token families and
+// revoked tokens that have expired and OTP sends that have left the rate
+// limit window.
+type OTPSweeper struct {
+	otpRepository          repository.OTPRepository
+	mfaRepository          repository.MFARepository
+	refreshTokenRepository repository.RefreshTokenRepository
+	revocations            repository.RevocationStore
+	otpSends               repository.OTPSendStore
+	cfg                    config.OTPSweeperConfig
+	// otpTTL is how long OTPs stored without an expiry are kept.
+	otpTTL time.Duration
+}
+
+func NewOTPSweeper(otpRepository repository.OTPRepository, mfaRepository repository.MFARepository, refreshTokenRepository repository.RefreshTokenRepository, revocations repository.RevocationStore, otpSends repository.OTPSendStore, cfg config.OTPSweeperConfig, otpCfg config.OTPConfig) *OTPSweeper {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+
+	return &OTPSweeper{
+		otpRepository:          otpRepository,
+		mfaRepository:          mfaRepository,
+		refreshTokenRepository: refreshTokenRepository,
+		revocations:            revocations,
+		otpSends:               otpSends,
+		cfg:                    cfg,
+		otpTTL:                 otpCfg.MaxTTL(),
+	}
+}
+
+// Run sweeps immediately and then every interval until ctx is cancelled.
+func (s *OTPSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil {
+			otpSweeperErrorsTotal.Inc()
+			log.Printf("failed to sweep expired rows: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes OTPs, MFA challenges, refresh token families, revoked tokens
+// and OTP sends that expired more than the retention period ago, one batch at
+// a time, and returns how many rows were deleted. An OTP stored without an
+// expiry is taken to expire after the longest OTP TTL, and a send expires
+// once it has left the rate limit window.
+func (s *OTPSweeper) Sweep(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-s.cfg.Retention)
+
+	purged, err := s.sweep(ctx, before, func(before time.Time, limit int) (int64, error) {
+		return s.otpRepository.DeleteExpiredOTPs(before, before.Add(-s.otpTTL), limit)
+	}, otpSweeperPurgedTotal)
+	if err != nil {
+		return purged, err
+	}
+
+	challenges, err := s.sweep(ctx, before, s.mfaRepository.DeleteExpiredChallenges, mfaChallengeSweeperPurgedTotal)
+	purged += challenges
+	if err != nil {
+		return purged, err
+	}
+
+	refreshTokens, err := s.sweep(ctx, before, s.refreshTokenRepository.DeleteExpiredRefreshTokens, refreshTokenSweeperPurgedTotal)
+	purged += refreshTokens
+	if err != nil {
+		return purged, err
+	}
+
+	tokens, err := s.sweep(ctx, before, s.revocations.DeleteExpiredTokens, revokedTokenSweeperPurgedTotal)
+	purged += tokens
+	if err != nil {
+		return purged, err
+	}
+
+	sends, err := s.sweep(ctx, before.Add(-otpSendWindow), s.otpSends.DeleteSendsBefore, otpSendSweeperPurgedTotal)
+
+	return purged + sends, err
+}
+
+func (s *OTPSweeper) sweep(ctx context.Context, before time.Time, deleteBatch func(before time.Time, limit int) (int64, error), purgedTotal prometheus.Counter) (int64, error) {
+	var purged int64
+	for ctx.Err() == nil {
+		deleted, err := deleteBatch(before, s.cfg.BatchSize)
+		purged += deleted
+		purgedTotal.Add(float64(deleted))
+		if err != nil {
+			return purged, err
+		}
+		if deleted < int64(s.cfg.BatchSize) {
+			break
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+)
+
+func TestOTPSweeperSweep(t *testing.T) {
+	db := newTestDB(t, &models.OTP{}, &models.MFAChallenge{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.OTPSend{})
+	now := time.Now()
+
+	otps := []struct {
+		id        string
+		createdAt time.Time
+		expiresAt interface{}
+		want      bool
+	}{
+		{"expired", now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), false},
+		{"expired within retention", now.Add(-90 * time.Minute), now.Add(-30 * time.Minute), true},
+		{"live", now.Add(-time.Minute), now.Add(time.Minute), true},
+		{"legacy past the longest ttl", now.Add(-2 * time.Hour), nil, false},
+		{"legacy within the longest ttl", now.Add(-70 * time.Minute), nil, true},
+		{"legacy zero expiry", now.Add(-2 * time.Hour), time.Time{}, false},
+	}
+	for _, otp := range otps {
+		if err := db.Create(&models.OTP{ID: otp.id, Phone: "+919876543210", CreatedAt: otp.createdAt}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Model(&models.OTP{}).Where("id = ?", otp.id).UpdateColumn("expires_at", otp.expiresAt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	challenges := []struct {
+		id        string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"expired", now.Add(-2 * time.Hour), false},
+		{"live", now.Add(time.Minute), true},
+	}
+	for _, challenge := range challenges {
+		if err := db.Create(&models.MFAChallenge{ID: challenge.id, TokenHash: challenge.id, ExpiresAt: challenge.expiresAt}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refreshTokens := []struct {
+		id        string
+		familyID  string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"expired family", "old", now.Add(-2 * time.Hour), false},
+		{"rotated in a live family", "live", now.Add(-2 * time.Hour), true},
+		{"latest in a live family", "live", now.Add(time.Hour), true},
+	}
+	for _, token := range refreshTokens {
+		if err := db.Create(&models.RefreshToken{ID: token.id, FamilyID: token.familyID, TokenHash: token.id, ExpiresAt: token.expiresAt}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweeper := NewOTPSweeper(
+		repository.NewOTPRepository(db),
+		repository.NewMFARepository(db),
+		repository.NewRefreshTokenRepository(db),
+		repository.NewRevocationStore(db),
+		repository.NewMemoryOTPSendStore(),
+		config.OTPSweeperConfig{Retention: time.Hour, BatchSize: 1},
+		config.OTPConfig{Login: config.OTPPurposeConfig{TTL: 5 * time.Minute}, PasswordReset: config.OTPPurposeConfig{TTL: 15 * time.Minute}},
+	)
+	purged, err := sweeper.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 5 {
+		t.Errorf("Sweep() purged %d rows, want 5", purged)
+	}
+
+	exists := func(model interface{}, id string) bool {
+		var count int64
+		if err := db.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+	for _, otp := range otps {
+		if got := exists(&models.OTP{}, otp.id); got != otp.want {
+			t.Errorf("OTP %q kept = %v, want %v", otp.id, got, otp.want)
+		}
+	}
+	for _, challenge := range challenges {
+		if got := exists(&models.MFAChallenge{}, challenge.id); got != challenge.want {
+			t.Errorf("MFA challenge %q kept = %v, want %v", challenge.id, got, challenge.want)
+		}
+	}
+	for _, token := range refreshTokens {
+		if got := exists(&models.RefreshToken{}, token.id); got != token.want {
+			t.Errorf("refresh token %q kept = %v, want %v", token.id, got, token.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+)
+
+var (
+	ErrPasswordRequired  = errors.New("password is required")
+	ErrPasswordTooShort  = errors.New("password is too short")
+	ErrPasswordTooCommon = errors.New("password is too common")
+	ErrPasswordIsPhone   = errors.New("password can't be your phone number")
+)
+
+type PasswordPolicy struct {
+	minLength int
+	common    map[string]bool
+}
+
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{minLength: cfg.MinLength, common: make(map[string]bool)}
+
+	if cfg.CommonPasswordsFile == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.CommonPasswordsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open common passwords file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			policy.common[strings.ToLower(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read common passwords file: %w", err)
+	}
+
+	return policy, nil
+}
+
+// Validate checks a new password for the user with the given phone number.
+func (p *PasswordPolicy) Validate(password string, phone string) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrPasswordTooShort, p.minLength)
+	}
+
+	if p.common[strings.ToLower(password)] {
+		return ErrPasswordTooCommon
+	}
+
+	// Catches the number with or without its country code.
+	digits := onlyDigits(password)
+	if digits != "" && digits == strings.TrimPrefix(password, "+") && strings.HasSuffix(onlyDigits(phone), digits) {
+		return ErrPasswordIsPhone
+	}
+
+	return nil
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"github.com/tanush-128/openzo_backend/user/internal/utils"
+	"gorm.io/gorm"
+)
+
+type PasswordResetRequest struct {
+	Phone          string `json:"phone" binding:"required"`
+	VerificationId string `json:"verification_id" binding:"required"`
+	OTP            string `json:"otp" binding:"required"`
+	NewPassword    string `json:"new_password" binding:"required"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+var ErrInvalidCurrentPassword = errors.New("current password is incorrect")
+
+type PasswordService interface {
+	ForgotPassword(ctx *gin.Context, phone string) (string, error)
+	ResetPassword(ctx *gin.Context, req PasswordResetRequest) error
+	ChangePassword(ctx *gin.Context, req PasswordChangeRequest) (TokenPair, error)
+}
+
+type passwordService struct {
+	userRepository repository.UserRepository
+	otpService     OTPService
+	tokenService   TokenService
+	policy         *PasswordPolicy
+	phoneConfig    config.PhoneConfig
+}
+
+func NewPasswordService(userRepository repository.UserRepository,
+	otpService OTPService,
+	tokenService TokenService,
+	policy *PasswordPolicy,
+	phoneConfig config.PhoneConfig,
+) PasswordService {
+	return &passwordService{userRepository: userRepository, otpService: otpService, tokenService: tokenService, policy: policy, phoneConfig: phoneConfig}
+}
+
+// ForgotPassword sends a password reset OTP to a registered phone number and
+// returns its verification id. Unregistered numbers get a decoy id instead,
+// and the OTP is sent in the background so both answer equally fast; callers
+// can't use it to find out which numbers have accounts.
+func (s *passwordService) ForgotPassword(ctx *gin.Context, phone string) (string, error) {
+	phone, err := phonenumber.Normalize(phone, s.phoneConfig.DefaultRegion)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepository.GetUserByMobile(phone)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.otpService.GenerateDecoyOTP(ctx, phone)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return s.otpService.GenerateOTPInBackground(ctx, phone, models.OTPPurposePasswordReset, user.ID)
+}
+
+// ResetPassword sets a new password once the reset OTP is verified and signs
+// the user out of every session.
+func (s *passwordService) ResetPassword(ctx *gin.Context, req PasswordResetRequest) error {
+	phone, err := phonenumber.Normalize(req.Phone, s.phoneConfig.DefaultRegion)
+	if err != nil {
+		return err
+	}
+	req.Phone = phone
+
+	if err := s.policy.Validate(req.NewPassword, req.Phone); err != nil {
+		return err
+	}
+
+	user, err := s.userRepository.GetUserByMobile(req.Phone)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidOTP
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.otpService.CheckOTP(ctx, req.Phone, req.VerificationId, req.OTP, models.OTPPurposePasswordReset, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		return err
+	}
+
+	return s.tokenService.RevokeUserTokens(ctx, user.ID)
+}
+
+// ChangePassword replaces the authenticated user's password. Every other
+// session is signed out, and the caller gets a fresh token pair to stay
+// signed in with.
+func (s *passwordService) ChangePassword(ctx *gin.Context, req PasswordChangeRequest) (TokenPair, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	user, err := s.userRepository.GetUserByID(claims.UserID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	// Accounts created through OTP have no password to check yet.
+	if user.Password != nil && *user.Password != "" {
+		if err := utils.CheckPasswordHash(req.CurrentPassword, *user.Password); err != nil {
+			return TokenPair{}, ErrInvalidCurrentPassword
+		}
+	}
+
+	if err := s.policy.Validate(req.NewPassword, user.Phone); err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.tokenService.RevokeUserTokens(ctx, user.ID); err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.tokenService.IssueTokens(ctx, user.ID, claims.MFA)
+}
+
+func (s *passwordService) setPassword(user models.User, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = &hashedPassword
+
+	_, err = s.userRepository.UpdateUser(user)
+
+	return err
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPhoneInUse           = errors.New("phone number belongs to another account")
+	ErrPhoneUnchanged       = errors.New("phone number is already the account's number")
+	ErrOldPhoneNotConfirmed = errors.New("an OTP sent to the current phone number is required")
+)
+
+type PhoneChangeRequest struct {
+	PhoneNo string `json:"phone_no" binding:"required"`
+}
+
+// PhoneChangeStart holds the verification ids of the OTPs sent to the new
+// number and, when it has to be confirmed too, the current one.
+type PhoneChangeStart struct {
+	VerificationId    string `json:"verification_id"`
+	OldVerificationId string `json:"old_verification_id,omitempty"`
+}
+
+type PhoneChangeConfirmRequest struct {
+	PhoneNo           string `json:"phone_no" binding:"required"`
+	VerificationId    string `json:"verification_id" binding:"required"`
+	OTP               string `json:"otp" binding:"required"`
+	OldVerificationId string `json:"old_verification_id"`
+	OldOTP            string `json:"old_otp"`
+}
+
+type PhoneChangeService interface {
+	StartPhoneChange(ctx *gin.Context, req PhoneChangeRequest) (PhoneChangeStart, error)
+	ConfirmPhoneChange(ctx *gin.Context, req PhoneChangeConfirmRequest) (models.User, error)
+}
+
+type phoneChangeService struct {
+	userRepository        repository.UserRepository
+	phoneChangeRepository repository.PhoneChangeRepository
+	otpService            OTPService
+	events                EventPublisher
+	cfg                   config.PhoneConfig
+}
+
+func NewPhoneChangeService(userRepository repository.UserRepository,
+	phoneChangeRepository repository.PhoneChangeRepository,
+	otpService OTPService,
+	events EventPublisher,
+	cfg config.PhoneConfig,
+) PhoneChangeService {
+	return &phoneChangeService{
+		userRepository:        userRepository,
+		phoneChangeRepository: phoneChangeRepository,
+		otpService:            otpService,
+		events:                events,
+		cfg:                   cfg,
+	}
+}
+
+// StartPhoneChange sends a phone change OTP to the new number and, if
+// configured, to the authenticated user's current number.
+func (s *phoneChangeService) StartPhoneChange(ctx *gin.Context, req PhoneChangeRequest) (PhoneChangeStart, error) {
+	user, newPhone, err := s.prepare(ctx, req.PhoneNo)
+	if err != nil {
+		return PhoneChangeStart{}, err
+	}
+
+	var start PhoneChangeStart
+	start.VerificationId, err = s.otpService.GenerateOTPForPurpose(ctx, newPhone, models.OTPPurposePhoneChange, user.ID)
+	if err != nil {
+		return PhoneChangeStart{}, err
+	}
+
+	if s.confirmsOldPhone(user) {
+		start.OldVerificationId, err = s.otpService.GenerateOTPForPurpose(ctx, user.Phone, models.OTPPurposePhoneChange, user.ID)
+		if err != nil {
+			return PhoneChangeStart{}, err
+		}
+	}
+
+	return start, nil
+}
+
+// ConfirmPhoneChange checks the OTPs sent by StartPhoneChange, then moves
+// the user to the new number, records the change and publishes a
+// PhoneChangedEvent.
+func (s *phoneChangeService) ConfirmPhoneChange(ctx *gin.Context, req PhoneChangeConfirmRequest) (models.User, error) {
+	user, newPhone, err := s.prepare(ctx, req.PhoneNo)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if s.confirmsOldPhone(user) {
+		if req.OldVerificationId == "" || req.OldOTP == "" {
+			return models.User{}, ErrOldPhoneNotConfirmed
+		}
+		if err := s.otpService.CheckOTP(ctx, user.Phone, req.OldVerificationId, req.OldOTP, models.OTPPurposePhoneChange, user.ID); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	if err := s.otpService.CheckOTP(ctx, newPhone, req.VerificationId, req.OTP, models.OTPPurposePhoneChange, user.ID); err != nil {
+		return models.User{}, err
+	}
+
+	change, err := s.phoneChangeRepository.ChangePhone(user.ID, newPhone, ctx.ClientIP())
+	if errors.Is(err, repository.ErrPhoneInUse) {
+		return models.User{}, ErrPhoneInUse
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The change is already committed, so a failed publish is only logged.
+	err = s.events.Publish(ctx, user.ID, PhoneChangedEvent{
+		Type:      EventPhoneChanged,
+		UserID:    change.UserID,
+		OldPhone:  change.OldPhone,
+		NewPhone:  change.NewPhone,
+		ChangedAt: change.ChangedAt,
+	})
+	if err != nil {
+		log.Printf("failed to publish phone change of user %s: %v", user.ID, err)
+	}
+
+	return s.userRepository.GetUserByID(user.ID)
+}
+
+// prepare loads the authenticated user and normalizes the new number,
+// rejecting numbers the user already has or another account uses.
+func (s *phoneChangeService) prepare(ctx *gin.Context, phone string) (models.User, string, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	user, err := s.userRepository.GetUserByID(claims.UserID)
+	if err != nil {
+		return models.User{}, "", err
+	}
+
+	newPhone, err := phonenumber.Normalize(phone, s.cfg.DefaultRegion)
+	if err != nil {
+		return models.User{}, "", err
+	}
+	if newPhone == user.Phone {
+		return models.User{}, "", ErrPhoneUnchanged
+	}
+
+	_, err = s.userRepository.GetUserByMobile(newPhone)
+	if err == nil {
+		return models.User{}, "", ErrPhoneInUse
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{}, "", err
+	}
+
+	return user, newPhone, nil
+}
+
+func (s *phoneChangeService) confirmsOldPhone(user models.User) bool {
+	return s.cfg.ConfirmOldPhone && user.Phone != ""
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+type RoleService interface {
+	GetRoles(ctx *gin.Context) ([]models.Role, error)
+	GrantRole(ctx *gin.Context, userID string, roleName string) (models.User, error)
+	RevokeRole(ctx *gin.Context, userID string, roleName string) (models.User, error)
+}
+
+type roleService struct {
+	roleRepository repository.RoleRepository
+	userRepository repository.UserRepository
+	revocations    repository.RevocationStore
+}
+
+func NewRoleService(roleRepository repository.RoleRepository,
+	userRepository repository.UserRepository,
+	revocations repository.RevocationStore,
+) RoleService {
+	return &roleService{roleRepository: roleRepository, userRepository: userRepository, revocations: revocations}
+}
+
+func (s *roleService) GetRoles(ctx *gin.Context) ([]models.Role, error) {
+	return s.roleRepository.GetRoles()
+}
+
+func (s *roleService) GrantRole(ctx *gin.Context, userID string, roleName string) (models.User, error) {
+	if err := s.checkUserAndRole(userID, roleName); err != nil {
+		return models.User{}, err
+	}
+
+	if err := s.roleRepository.AddUserRole(userID, roleName); err != nil {
+		return models.User{}, err
+	}
+
+	return s.userRepository.GetUserByID(userID)
+}
+
+// RevokeRole removes the role and revokes the user's access tokens, which
+// still list it, so their next refresh picks up the reduced set.
+func (s *roleService) RevokeRole(ctx *gin.Context, userID string, roleName string) (models.User, error) {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+	if claims.UserID == userID && roleName == models.RoleAdmin {
+		return models.User{}, ErrForbidden
+	}
+
+	if err := s.checkUserAndRole(userID, roleName); err != nil {
+		return models.User{}, err
+	}
+
+	if err := s.roleRepository.RemoveUserRole(userID, roleName); err != nil {
+		return models.User{}, err
+	}
+
+	if err := s.revocations.RevokeAllForUser(userID, time.Now()); err != nil {
+		return models.User{}, err
+	}
+
+	return s.userRepository.GetUserByID(userID)
+}
+
+func (s *roleService) checkUserAndRole(userID string, roleName string) error {
+	if _, err := s.roleRepository.GetRoleByName(roleName); errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRoleNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := s.userRepository.GetUserByID(userID); errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
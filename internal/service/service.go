@@ -1,45 +1,81 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/repository"
 	"github.com/tanush-128/openzo_backend/user/internal/utils"
 )
 
+var (
+	ErrTooManyUserIDs = errors.New("too many user ids")
+	ErrUnknownPincode = errors.New("no location found for pincode")
+)
+
 type UserService interface {
 
 	//CRUD
-	CreateUser(ctx *gin.Context, req models.User) (models.User, string, error)
-	GetUserByID(ctx *gin.Context, id string) (models.User, error)
+	CreateUser(ctx *gin.Context, req models.User) (models.User, TokenPair, error)
+	GetUserByID(ctx context.Context, id string) (models.User, error)
 	GetUserByEmail(ctx *gin.Context, email string) (models.User, error)
-	UpdateUser(ctx *gin.Context, req models.User) (models.User, error)
+	GetUsersByIDs(ctx context.Context, ids []string) (map[string]models.User, error)
+	UpdateUser(ctx context.Context, req models.User) (models.User, error)
 
 	//Authentication
-	UserSignIn(ctx *gin.Context, req UserSignInRequest) (string, error)
+	UserSignIn(ctx *gin.Context, req UserSignInRequest) (SignInResult, error)
 	GetUserWithJWT(ctx *gin.Context, token string) (models.User, error)
 }
 
 type userService struct {
 	userRepository repository.UserRepository
+	tokenService   TokenService
+	lockoutService LockoutService
+	mfaService     MFAService
+	otpService     OTPService
+	passwordPolicy *PasswordPolicy
+	phoneConfig    config.PhoneConfig
+	usersConfig    config.UsersConfig
 }
 
-func NewUserService(userRepository repository.UserRepository) UserService {
-	return &userService{userRepository: userRepository}
+func NewUserService(userRepository repository.UserRepository, tokenService TokenService, lockoutService LockoutService, mfaService MFAService, otpService OTPService, passwordPolicy *PasswordPolicy, phoneConfig config.PhoneConfig, usersConfig config.UsersConfig) UserService {
+	return &userService{userRepository: userRepository, tokenService: tokenService, lockoutService: lockoutService, mfaService: mfaService, otpService: otpService, passwordPolicy: passwordPolicy, phoneConfig: phoneConfig, usersConfig: usersConfig}
 }
 
+// CreateUserRequest is a sign up: the new user and their password, which
+// models.User never reads from or writes to JSON.
 type CreateUserRequest struct {
-	Phone string `json:"phone" binding:"required"`
+	models.User
+	Password *string `json:"password"`
 }
 
-func (s *userService) CreateUser(ctx *gin.Context, req models.User) (models.User, string, error) {
+type BatchGetUsersRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+func (s *userService) CreateUser(ctx *gin.Context, req models.User) (models.User, TokenPair, error) {
+	if err := s.normalizePhone(&req); err != nil {
+		return models.User{}, TokenPair{}, err
+	}
+
+	if req.Password == nil || *req.Password == "" {
+		return models.User{}, TokenPair{}, ErrPasswordRequired
+	}
+	if err := s.passwordPolicy.Validate(*req.Password, req.Phone); err != nil {
+		return models.User{}, TokenPair{}, err
+	}
 
 	if req.Latitude != nil && req.Longitude != nil {
 		location, err := utils.GetLocation(*req.Latitude, *req.Longitude)
 		if err != nil {
-			return models.User{}, "", err
+			return models.User{}, TokenPair{}, err
 		}
 
 		req.City = &location.Address.City
@@ -52,25 +88,46 @@ func (s *userService) CreateUser(ctx *gin.Context, req models.User) (models.User
 
 	hashedPassword, err := utils.HashPassword(*req.Password)
 	if err != nil {
-		return models.User{}, "", err
+		return models.User{}, TokenPair{}, err
 	}
 
 	req.Password = &hashedPassword
+	// Sign up is unauthenticated, so the client can't choose the account's
+	// id, role or verification state.
+	req.ID = ""
+	req.Role = ""
+	req.Roles = nil
+	req.IsVerified = false
+	// The email is only set once the address is confirmed.
+	email := req.Email
+	req.Email = nil
+	req.PendingEmail = nil
+	req.EmailVerified = false
 
 	createdUser, err := s.userRepository.CreateUser(req)
 	if err != nil {
-		return models.User{}, "", err // Propagate error
+		return models.User{}, TokenPair{}, err // Propagate error
+	}
+
+	// The account is usable without an email, so a failed confirmation mail
+	// doesn't fail the sign up; it can be requested again.
+	if email != nil && *email != "" {
+		if err := s.otpService.RequestEmailChange(ctx, createdUser.ID, *email); err != nil {
+			log.Printf("failed to request email confirmation for user %s: %v", createdUser.ID, err)
+		} else {
+			createdUser.PendingEmail = email
+		}
 	}
 
-	token, err := CreateJwtToken(createdUser.ID)
+	tokens, err := s.tokenService.IssueTokens(ctx, createdUser.ID, false)
 	if err != nil {
-		return models.User{}, "", err
+		return models.User{}, TokenPair{}, err
 	}
 
-	return createdUser, token, nil
+	return createdUser, tokens, nil
 }
 
-func (s *userService) GetUserByID(ctx *gin.Context, id string) (models.User, error) {
+func (s *userService) GetUserByID(ctx context.Context, id string) (models.User, error) {
 	user, err := s.userRepository.GetUserByID(id)
 	if err != nil {
 		return models.User{}, err
@@ -79,6 +136,36 @@ func (s *userService) GetUserByID(ctx *gin.Context, id string) (models.User, err
 	return user, nil
 }
 
+// GetUsersByIDs returns the users with the given ids, keyed by id. Ids
+// without a user are left out, and asking for
+// more than BatchMaxIDs distinct ids fails with ErrTooManyUserIDs.
+func (s *userService) GetUsersByIDs(ctx context.Context, ids []string) (map[string]models.User, error) {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	if len(unique) > s.usersConfig.BatchMaxIDs {
+		return nil, fmt.Errorf("%w: at most %d can be looked up at once", ErrTooManyUserIDs, s.usersConfig.BatchMaxIDs)
+	}
+
+	users, err := s.userRepository.GetUsersByIDs(unique)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	return byID, nil
+}
+
 func (s *userService) GetUserByEmail(ctx *gin.Context, email string) (models.User, error) {
 	user, err := s.userRepository.GetUserByEmail(email)
 	if err != nil {
@@ -88,7 +175,7 @@ func (s *userService) GetUserByEmail(ctx *gin.Context, email string) (models.Use
 	return user, nil
 }
 
-func (s *userService) UpdateUser(ctx *gin.Context, req models.User) (models.User, error) {
+func (s *userService) UpdateUser(ctx context.Context, req models.User) (models.User, error) {
 
 	var user models.User
 
@@ -111,23 +198,46 @@ func (s *userService) UpdateUser(ctx *gin.Context, req models.User) (models.User
 		address := (location.Address.HouseNumber + ", " + location.Address.Road + ", " + location.Address.City + ", " + location.Address.State + ", " + location.Address.Country)
 
 		req.Address = &address
-	} else if user.Pincode != req.Pincode {
+	} else if req.Pincode != nil && *req.Pincode != "" && (user.Pincode == nil || *user.Pincode != *req.Pincode) {
+		// Only a new pincode is looked up, so other updates keep the
+		// location the user already has.
 		locations, err := utils.GetLocationByPincode(*req.Pincode)
 		if err != nil {
 			return models.User{}, err
 		}
+		if len(locations) == 0 {
+			return models.User{}, fmt.Errorf("%w %s", ErrUnknownPincode, *req.Pincode)
+		}
 		location := locations[0]
 
 		req.Address = &location.DisplayName
 		req.Latitude = &location.Lat
 		req.Longitude = &location.Lon
-		city := strings.Split(location.DisplayName, ", ")[0]
-		req.City = &city
-		req.State = &strings.Split(location.DisplayName, ", ")[3]
+		parts := strings.Split(location.DisplayName, ", ")
+		req.City = &parts[0]
+		if len(parts) > 3 {
+			req.State = &parts[3]
+		}
 
 	}
 	req.Password = user.Password
 	req.Role = user.Role
+	// The phone number only changes through the phone change flow.
+	req.Phone = user.Phone
+	req.IsVerified = user.IsVerified
+	// A new email only replaces the current one once it is confirmed. The
+	// change is requested first, so a rejected email leaves nothing saved.
+	newEmail := req.Email
+	req.Email = user.Email
+	req.PendingEmail = user.PendingEmail
+	req.EmailVerified = user.EmailVerified
+	if newEmail != nil && *newEmail != "" && !sameEmail(user.Email, newEmail) && !sameEmail(user.PendingEmail, newEmail) {
+		if err := s.otpService.RequestEmailChange(ctx, user.ID, *newEmail); err != nil {
+			return models.User{}, err
+		}
+		req.PendingEmail = newEmail
+	}
+
 	updatedUser, err := s.userRepository.UpdateUser(req)
 	if err != nil {
 		return models.User{}, err
@@ -135,3 +245,26 @@ func (s *userService) UpdateUser(ctx *gin.Context, req models.User) (models.User
 
 	return updatedUser, nil
 }
+
+// normalizePhone puts the user's phone number, if any, in E.164 form.
+func (s *userService) normalizePhone(user *models.User) error {
+	if user.Phone == "" {
+		return nil
+	}
+
+	phone, err := phonenumber.Normalize(user.Phone, s.phoneConfig.DefaultRegion)
+	if err != nil {
+		return err
+	}
+	user.Phone = phone
+
+	return nil
+}
+
+func sameEmail(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return strings.EqualFold(*a, *b)
+}
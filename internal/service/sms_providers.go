@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// twoFactorSender sends OTPs through the 2factor.in OTP API, which fills the
+// code into one of the account's approved SMS templates or reads it out in a
+// voice call.
+type twoFactorSender struct {
+	apiKey   string
+	channel  string
+	template string
+	client   *http.Client
+}
+
+func NewTwoFactorSender(apiKey string, template string, timeout time.Duration) SMSSender {
+	return &twoFactorSender{apiKey: apiKey, channel: "SMS", template: template, client: &http.Client{Timeout: timeout}}
+}
+
+func NewTwoFactorVoiceSender(apiKey string, timeout time.Duration) OTPSender {
+	return &twoFactorSender{apiKey: apiKey, channel: "VOICE", client: &http.Client{Timeout: timeout}}
+}
+
+type twoFactorResponse struct {
+	Status  string `json:"Status"`
+	Details string `json:"Details"`
+}
+
+func (s *twoFactorSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	endpoint := "https://2factor.in/API/V1/" + url.PathEscape(s.apiKey) +
+		"/" + s.channel + "/" + url.PathEscape(phone) + "/" + url.PathEscape(otp)
+	if s.template != "" {
+		endpoint += "/" + url.PathEscape(s.template)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var body twoFactorResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&body); err != nil {
+		return fmt.Errorf("2factor: unexpected response (HTTP %d): %w", res.StatusCode, err)
+	}
+	if res.StatusCode != http.StatusOK || body.Status != "Success" {
+		return fmt.Errorf("2factor: %s (HTTP %d)", body.Details, res.StatusCode)
+	}
+
+	return nil
+}
+
+// twilioSender sends the OTP as a plain text message through the Twilio
+// Messages API, or any API compatible with it. Addresses are prefixed with
+// "whatsapp:" to send over WhatsApp instead of SMS.
+type twilioSender struct {
+	baseURL    string
+	accountSID string
+	authToken  string
+	from       string
+	prefix     string
+	message    string
+	client     *http.Client
+}
+
+func NewTwilioSender(baseURL string, accountSID string, authToken string, from string, message string, timeout time.Duration) SMSSender {
+	return &twilioSender{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		message:    message,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+func NewTwilioWhatsAppSender(baseURL string, accountSID string, authToken string, from string, message string, timeout time.Duration) OTPSender {
+	sender := NewTwilioSender(baseURL, accountSID, authToken, from, message, timeout).(*twilioSender)
+	sender.prefix = "whatsapp:"
+
+	return sender
+}
+
+type twilioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *twilioSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	form := url.Values{}
+	form.Set("To", s.prefix+phone)
+	form.Set("From", s.prefix+s.from)
+	form.Set("Body", fmt.Sprintf(s.message, otp))
+
+	return twilioPost(ctx, s.client, s.baseURL, s.accountSID, s.authToken, "Messages.json", form)
+}
+
+// twilioVoiceSender calls the phone number through the Twilio Calls API and
+// reads the code out twice.
+type twilioVoiceSender struct {
+	baseURL    string
+	accountSID string
+	authToken  string
+	from       string
+	message    string
+	client     *http.Client
+}
+
+func NewTwilioVoiceSender(baseURL string, accountSID string, authToken string, from string, message string, timeout time.Duration) OTPSender {
+	return &twilioVoiceSender{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		message:    message,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *twilioVoiceSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	var say strings.Builder
+	xml.EscapeText(&say, []byte(fmt.Sprintf(s.message, spellDigits(otp))))
+
+	form := url.Values{}
+	form.Set("To", phone)
+	form.Set("From", s.from)
+	form.Set("Twiml", "<Response><Say>"+say.String()+"</Say><Pause length=\"1\"/><Say>"+say.String()+"</Say></Response>")
+
+	return twilioPost(ctx, s.client, s.baseURL, s.accountSID, s.authToken, "Calls.json", form)
+}
+
+// spellDigits separates the digits of a code so they are read out one by
+// one rather than as a number.
+func spellDigits(code string) string {
+	return strings.Join(strings.Split(code, ""), ", ")
+}
+
+func twilioPost(ctx context.Context, client *http.Client, baseURL string, accountSID string, authToken string, resource string, form url.Values) error {
+	endpoint := baseURL + "/2010-04-01/Accounts/" + url.PathEscape(accountSID) + "/" + resource
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(accountSID, authToken)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	var body twilioError
+	json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&body)
+
+	return fmt.Errorf("twilio: %s (HTTP %d, code %d)", body.Message, res.StatusCode, body.Code)
+}
+
+// whatsAppCloudSender sends an authentication template through the WhatsApp
+// Cloud API. The template's body and copy code button both take the code.
+type whatsAppCloudSender struct {
+	baseURL       string
+	phoneNumberID string
+	accessToken   string
+	template      string
+	language      string
+	client        *http.Client
+}
+
+func NewWhatsAppCloudSender(baseURL string, phoneNumberID string, accessToken string, template string, language string, timeout time.Duration) OTPSender {
+	return &whatsAppCloudSender{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		phoneNumberID: phoneNumberID,
+		accessToken:   accessToken,
+		template:      template,
+		language:      language,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+type whatsAppParameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type whatsAppComponent struct {
+	Type       string              `json:"type"`
+	SubType    string              `json:"sub_type,omitempty"`
+	Index      string              `json:"index,omitempty"`
+	Parameters []whatsAppParameter `json:"parameters"`
+}
+
+type whatsAppError struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+func (s *whatsAppCloudSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	parameters := []whatsAppParameter{{Type: "text", Text: otp}}
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                strings.TrimPrefix(phone, "+"),
+		"type":              "template",
+		"template": map[string]interface{}{
+			"name":     s.template,
+			"language": map[string]string{"code": s.language},
+			"components": []whatsAppComponent{
+				{Type: "body", Parameters: parameters},
+				{Type: "button", SubType: "url", Index: "0", Parameters: parameters},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.baseURL + "/" + url.PathEscape(s.phoneNumberID) + "/messages"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	var resBody whatsAppError
+	json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&resBody)
+
+	return fmt.Errorf("whatsapp: %s (HTTP %d, code %d)", resBody.Error.Message, res.StatusCode, resBody.Error.Code)
+}
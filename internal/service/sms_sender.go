@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanush-128/openzo_backend/user/config"
+)
+
+// SMSSender delivers an OTP to a phone number. Implementations return an
+// error if the provider didn't accept the message.
+type SMSSender interface {
+	SendOTP(ctx context.Context, phone string, otp string) error
+}
+
+// NewSMSSender returns the sender selected by cfg.SMS.Provider.
+func NewSMSSender(cfg *config.Config) (SMSSender, error) {
+	smsConfig := cfg.SMS
+
+	switch strings.ToLower(smsConfig.Provider) {
+	case "", "2factor":
+		apiKey := smsConfig.TwoFactorAPIKey
+		if apiKey == "" {
+			apiKey = cfg.SMS_API_KEY
+		}
+		return NewTwoFactorSender(apiKey, smsConfig.TwoFactorTemplate, smsConfig.Timeout), nil
+	case "twilio":
+		return NewTwilioSender(smsConfig.TwilioBaseURL, smsConfig.TwilioAccountSID, smsConfig.TwilioAuthToken, smsConfig.TwilioFrom, smsConfig.Message, smsConfig.Timeout), nil
+	case "console":
+		return NewWriterSMSSender(os.Stdout, smsConfig.Message), nil
+	case "file":
+		file, err := os.OpenFile(smsConfig.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SMS file: %w", err)
+		}
+		return NewWriterSMSSender(file, smsConfig.Message), nil
+	case "recording":
+		return NewRecordingSMSSender(), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", smsConfig.Provider)
+	}
+}
+
+// writerSMSSender writes each message as a line instead of sending it, for
+// local development.
+type writerSMSSender struct {
+	mu      sync.Mutex
+	w       io.Writer
+	channel string
+	message string
+}
+
+func NewWriterSMSSender(w io.Writer, message string) SMSSender {
+	return NewWriterOTPSender(w, "SMS", message)
+}
+
+// NewWriterOTPSender is NewWriterSMSSender for any channel, labelling each
+// line with channel.
+func NewWriterOTPSender(w io.Writer, channel string, message string) OTPSender {
+	return &writerSMSSender{w: w, channel: channel, message: message}
+}
+
+func (s *writerSMSSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "%s %s to %s: %s\n", time.Now().Format(time.RFC3339), s.channel, phone, fmt.Sprintf(s.message, otp))
+
+	return err
+}
+
+// SentSMS is a message captured by RecordingSMSSender.
+type SentSMS struct {
+	Phone string
+	OTP   string
+}
+
+// RecordingSMSSender keeps every OTP it is asked to send so tests can read
+// them back. Setting Err makes every send fail with it.
+type RecordingSMSSender struct {
+	mu   sync.Mutex
+	sent []SentSMS
+	Err  error
+}
+
+func NewRecordingSMSSender() *RecordingSMSSender {
+	return &RecordingSMSSender{}
+}
+
+func (s *RecordingSMSSender) SendOTP(ctx context.Context, phone string, otp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+	s.sent = append(s.sent, SentSMS{Phone: phone, OTP: otp})
+
+	return nil
+}
+
+// Sent returns the messages sent so far.
+func (s *RecordingSMSSender) Sent() []SentSMS {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]SentSMS(nil), s.sent...)
+}
+
+// Last returns the most recent OTP sent to phone.
+func (s *RecordingSMSSender) Last(phone string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.sent) - 1; i >= 0; i-- {
+		if s.sent[i].Phone == phone {
+			return s.sent[i].OTP, true
+		}
+	}
+
+	return "", false
+}
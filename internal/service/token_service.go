@@ -0,0 +1,253 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// TokenPair is what a client receives after signing in: a short-lived access
+// token and the refresh token used to obtain the next one.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type TokenService interface {
+	IssueTokens(ctx *gin.Context, userID string, mfa bool) (TokenPair, error)
+	RefreshTokens(ctx *gin.Context, refreshToken string) (TokenPair, error)
+	Logout(ctx *gin.Context, refreshToken string) error
+	LogoutAll(ctx *gin.Context) error
+	RevokeUserTokens(ctx *gin.Context, userID string) error
+}
+
+type tokenService struct {
+	refreshTokenRepository repository.RefreshTokenRepository
+	userRepository         repository.UserRepository
+	revocations            repository.RevocationStore
+	keys                   *middlewares.KeySet
+	cfg                    *config.Config
+}
+
+func NewTokenService(refreshTokenRepository repository.RefreshTokenRepository,
+	userRepository repository.UserRepository,
+	revocations repository.RevocationStore,
+	keys *middlewares.KeySet,
+	cfg *config.Config,
+) TokenService {
+	return &tokenService{
+		refreshTokenRepository: refreshTokenRepository,
+		userRepository:         userRepository,
+		revocations:            revocations,
+		keys:                   keys,
+		cfg:                    cfg,
+	}
+}
+
+// IssueTokens starts a new refresh token family for the user. The user is
+// loaded here so the access token carries their current roles; mfa tells
+// whether the sign in passed two-factor authentication. Callers signing a
+// user in should go through MFAService.SignIn instead.
+func (s *tokenService) IssueTokens(ctx *gin.Context, userID string, mfa bool) (TokenPair, error) {
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	raw, err := generateRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	_, err = s.refreshTokenRepository.CreateRefreshToken(models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(raw),
+		ExpiresAt: time.Now().Add(s.cfg.JWT.RefreshTokenTTL),
+		MFA:       mfa,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.tokenPair(user, raw, mfa)
+}
+
+// RefreshTokens exchanges a refresh token for a new pair. Each refresh token
+// can be used once; presenting one that was already rotated means it has
+// leaked, so its whole family is revoked.
+func (s *tokenService) RefreshTokens(ctx *gin.Context, refreshToken string) (TokenPair, error) {
+	current, err := s.refreshTokenRepository.GetRefreshTokenByHash(hashRefreshToken(refreshToken))
+	if err != nil {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	if current.RevokedAt != nil {
+		if err := s.refreshTokenRepository.RevokeFamily(current.FamilyID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+
+	if current.ExpiresAt.Before(time.Now()) {
+		return TokenPair{}, ErrRefreshTokenExpired
+	}
+
+	user, err := s.userRepository.GetUserByID(current.UserID)
+	if err != nil {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	raw, err := generateRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	_, err = s.refreshTokenRepository.RotateRefreshToken(current, models.RefreshToken{
+		UserID:    current.UserID,
+		TokenHash: hashRefreshToken(raw),
+		ExpiresAt: time.Now().Add(s.cfg.JWT.RefreshTokenTTL),
+		MFA:       current.MFA,
+	})
+	if errors.Is(err, repository.ErrRefreshTokenUsed) {
+		if err := s.refreshTokenRepository.RevokeFamily(current.FamilyID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.tokenPair(user, raw, current.MFA)
+}
+
+// Logout revokes the access token the request was authenticated with and,
+// when given, the family of the refresh token issued alongside it.
+func (s *tokenService) Logout(ctx *gin.Context, refreshToken string) error {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+	userID := claims.UserID
+
+	if claims.ID != "" {
+		if err := s.revocations.RevokeToken(claims.ID, userID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	current, err := s.refreshTokenRepository.GetRefreshTokenByHash(hashRefreshToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	if current.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.refreshTokenRepository.RevokeFamily(current.FamilyID)
+}
+
+// LogoutAll signs the authenticated user out everywhere.
+func (s *tokenService) LogoutAll(ctx *gin.Context) error {
+	claims := ctx.MustGet("user").(*middlewares.Claims)
+
+	return s.RevokeUserTokens(ctx, claims.UserID)
+}
+
+// RevokeUserTokens revokes every access token issued to the user so far and
+// all of their refresh tokens.
+func (s *tokenService) RevokeUserTokens(ctx *gin.Context, userID string) error {
+	if err := s.revocations.RevokeAllForUser(userID, time.Now()); err != nil {
+		return err
+	}
+
+	return s.refreshTokenRepository.RevokeUserTokens(userID)
+}
+
+// tokenPair signs an access token for user. Without two-factor
+// authentication, the roles in MFA.REQUIRED_ROLES are left out of it.
+func (s *tokenService) tokenPair(user models.User, refreshToken string, mfa bool) (TokenPair, error) {
+	if !mfa {
+		user.Roles = withoutRoles(user.Roles, s.cfg.MFA.RequiredRoles)
+	}
+
+	accessToken, err := CreateJwtToken(s.keys, s.cfg.JWT, user, mfa)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.cfg.JWT.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// withoutRoles returns roles except those named in names.
+func withoutRoles(roles []models.Role, names []string) []models.Role {
+	kept := make([]models.Role, 0, len(roles))
+	for _, role := range roles {
+		if !hasName(names, role.Name) {
+			kept = append(kept, role)
+		}
+	}
+
+	return kept
+}
+
+// onlyRoles returns the roles named in names.
+func onlyRoles(roles []models.Role, names []string) []models.Role {
+	kept := make([]models.Role, 0, len(roles))
+	for _, role := range roles {
+		if hasName(names, role.Name) {
+			kept = append(kept, role)
+		}
+	}
+
+	return kept
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func generateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
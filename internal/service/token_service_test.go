@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tanush-128/openzo_backend/user/config"
+	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/repository"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB opens an empty in-memory database with the given models
+// migrated.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to file::memory: gets its own database.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func newTestContext(claims *middlewares.Claims) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("POST", "/", nil)
+	if claims != nil {
+		ctx.Set("user", claims)
+	}
+
+	return ctx
+}
+
+func newTestTokenService(t *testing.T) (TokenService, repository.UserRepository, repository.RefreshTokenRepository) {
+	t.Helper()
+
+	db := newTestDB(t, &models.User{}, &models.Role{}, &models.RefreshToken{})
+	cfg := &config.Config{JWT: config.JWTConfig{
+		SigningKeyID:    "test",
+		Keys:            []config.JWTKeyConfig{{ID: "test", Secret: "test-signing-secret"}},
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+	}}
+	keys, err := middlewares.NewKeySet(cfg.JWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRepository := repository.NewUserRepository(db)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
+	tokenService := NewTokenService(refreshTokenRepository, userRepository, repository.NewMemoryRevocationStore(), keys, cfg)
+
+	return tokenService, userRepository, refreshTokenRepository
+}
+
+func TestRefreshTokensRotates(t *testing.T) {
+	tokenService, userRepository, _ := newTestTokenService(t)
+	user, err := userRepository.CreateUser(models.User{Phone: "+919876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestContext(nil)
+
+	first, err := tokenService.IssueTokens(ctx, user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tokenService.RefreshTokens(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refreshing a fresh token: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	if _, err := tokenService.RefreshTokens(ctx, second.RefreshToken); err != nil {
+		t.Fatalf("refreshing the rotated token: %v", err)
+	}
+}
+
+func TestRefreshTokensReuseRevokesFamily(t *testing.T) {
+	tokenService, userRepository, _ := newTestTokenService(t)
+	user, err := userRepository.CreateUser(models.User{Phone: "+919876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestContext(nil)
+
+	stolen, err := tokenService.IssueTokens(ctx, user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokenService.IssueTokens(ctx, user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := tokenService.RefreshTokens(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"replaying the rotated token", stolen.RefreshToken, ErrRefreshTokenReused},
+		{"the family's latest token", latest.RefreshToken, ErrRefreshTokenReused},
+		{"an unknown token", "not-a-refresh-token", ErrInvalidRefreshToken},
+		{"another family", other.RefreshToken, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tokenService.RefreshTokens(ctx, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RefreshTokens() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshTokensRejectsExpired(t *testing.T) {
+	tokenService, userRepository, refreshTokenRepository := newTestTokenService(t)
+	user, err := userRepository.CreateUser(models.User{Phone: "+919876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := "expired-refresh-token"
+	_, err = refreshTokenRepository.CreateRefreshToken(models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(raw),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokenService.RefreshTokens(newTestContext(nil), raw); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("RefreshTokens() = %v, want %v", err, ErrRefreshTokenExpired)
+	}
+}
+
+func TestLogoutRejectsAnotherUsersRefreshToken(t *testing.T) {
+	tokenService, userRepository, _ := newTestTokenService(t)
+	owner, err := userRepository.CreateUser(models.User{Phone: "+919876543210"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestContext(nil)
+	tokens, err := tokenService.IssueTokens(ctx, owner.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestContext(&middlewares.Claims{UserID: "someone-else"})
+	if err := tokenService.Logout(other, tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Logout() = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := tokenService.RefreshTokens(ctx, tokens.RefreshToken); err != nil {
+		t.Fatalf("the owner's token stopped working: %v", err)
+	}
+}
@@ -21,6 +21,14 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// HashOTPWithSecret returns the hex HMAC-SHA256 of otp keyed with secret.
+func HashOTPWithSecret(otp string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(otp))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func HashNumberWithSecret(number int, secret string) string {
 	// Convert the number to a byte slice
 	numberBytes := []byte(fmt.Sprintf("%d", number))
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tanush-128/openzo_backend/user/config"
@@ -16,6 +23,7 @@ import (
 	"github.com/tanush-128/openzo_backend/user/internal/middlewares"
 	"github.com/tanush-128/openzo_backend/user/internal/models"
 	userpb "github.com/tanush-128/openzo_backend/user/internal/pb"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
 	"github.com/tanush-128/openzo_backend/user/internal/repository"
 	"github.com/tanush-128/openzo_backend/user/internal/service"
 )
@@ -58,23 +66,134 @@ func main() {
 		log.Fatal(fmt.Errorf("failed to load config: %w", err))
 	}
 
+	if cfg.OTP.Secret == "" {
+		if cfg.MODE == "production" {
+			log.Fatal("OTP.SECRET must be set in production")
+		}
+		log.Println("OTP.SECRET is not set, using a random secret; OTPs won't survive a restart")
+		cfg.OTP.Secret = uuid.New().String()
+	}
+	for _, key := range cfg.JWT.Keys {
+		if key.Secret != "" && key.Secret == cfg.OTP.Secret {
+			log.Fatal("OTP.SECRET must not be the same as a JWT key secret")
+		}
+	}
+
+	if !phonenumber.KnownRegion(cfg.Phone.DefaultRegion) {
+		log.Fatalf("PHONE.DEFAULT_REGION %q is not a supported region", cfg.Phone.DefaultRegion)
+	}
+	for i, reviewPhone := range cfg.OTP.ReviewPhones {
+		normalized, err := phonenumber.Normalize(reviewPhone, cfg.Phone.DefaultRegion)
+		if err != nil {
+			log.Fatalf("OTP.REVIEW_PHONES: %q: %v", reviewPhone, err)
+		}
+		cfg.OTP.ReviewPhones[i] = normalized
+	}
+
 	db, err := connectToDB(cfg) // Implement database connection logic
 	if err != nil {
 		log.Fatal(fmt.Errorf("failed to connect to database: %w", err))
 	}
 
+	// "normalize-phones" is a one-off migration run instead of the server.
+	// The unique phone index can't be built while duplicates remain, which
+	// is what the migration reports, so only the tables it reads are migrated.
+	if len(os.Args) > 1 && os.Args[1] == "normalize-phones" {
+		if err := db.Migrator().AutoMigrate(&models.User{}, &models.OTP{}); err != nil {
+			log.Fatal(fmt.Errorf("failed to migrate database: %w", err))
+		}
+		if err := normalizePhones(db, cfg.Phone.DefaultRegion, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(fmt.Errorf("failed to normalize phone numbers: %w", err))
+		}
+		return
+	}
+
+	if err := migrateDB(db); err != nil {
+		log.Fatal(fmt.Errorf("failed to migrate database: %w", err))
+	}
+
+	keys, err := middlewares.NewKeySet(cfg.JWT)
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to load jwt keys: %w", err))
+	}
+	revocationStore := repository.NewRevocationStore(db)
+	var verifyKeys middlewares.Keys = keys
+	if cfg.JWT.JWKSURL != "" {
+		verifyKeys = middlewares.NewRemoteKeySet(cfg.JWT.JWKSURL, cfg.JWT.JWKSRefreshInterval, cfg.JWT.JWKSMinRefreshInterval)
+	}
+	auth := middlewares.NewAuthenticator(cfg.JWT, verifyKeys, revocationStore)
+
 	userRepository := repository.NewUserRepository(db)
 
 	otpRepository := repository.NewOTPRepository(db)
 
-	userService := service.NewUserService(userRepository)
+	refreshTokenRepository := repository.NewRefreshTokenRepository(db)
 
-	otpService := service.NewOTPService(otpRepository, userRepository, cfg)
+	tokenService := service.NewTokenService(refreshTokenRepository, userRepository, revocationStore, keys, cfg)
+
+	loginThrottleRepository := repository.NewLoginThrottleRepository(db)
+	lockoutService := service.NewLockoutService(loginThrottleRepository, cfg.Lockout)
+
+	mfaRepository := repository.NewMFARepository(db)
+	mfaService := service.NewMFAService(mfaRepository, userRepository, tokenService, lockoutService, cfg.MFA)
+
+	smsSender, err := service.NewSMSSender(cfg)
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to create SMS sender: %w", err))
+	}
+	otpChannels, err := service.NewOTPChannels(cfg, smsSender)
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to create OTP channels: %w", err))
+	}
+	otpSendStore := repository.NewOTPSendStore(db)
+	if cfg.OTPRateLimit.Store == "memory" {
+		otpSendStore = repository.NewMemoryOTPSendStore()
+	}
+	otpRateLimiter := service.NewOTPRateLimiter(otpSendStore, cfg.OTPRateLimit)
+	mailer, err := service.NewMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to create mailer: %w", err))
+	}
+	otpService := service.NewOTPService(otpRepository, userRepository, mfaService, otpChannels, mailer, otpRateLimiter, cfg)
+
+	passwordPolicy, err := service.NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to load password policy: %w", err))
+	}
+
+	userService := service.NewUserService(userRepository, tokenService, lockoutService, mfaService, otpService, passwordPolicy, cfg.Phone, cfg.Users)
+	passwordService := service.NewPasswordService(userRepository, otpService, tokenService, passwordPolicy, cfg.Phone)
 
 	addressRepository := repository.NewAddressRepository(db)
 	addressService := service.NewAddressService(addressRepository)
 
+	roleRepository := repository.NewRoleRepository(db)
+	roleService := service.NewRoleService(roleRepository, userRepository, revocationStore)
+
 	conf := ReadConfig()
+	events := service.NewWriterEventPublisher(os.Stdout)
+	var eventProducer *kafka.Producer
+	switch cfg.Events.Publisher {
+	case "kafka":
+		p, err := kafka.NewProducer(&conf)
+		if err != nil {
+			log.Fatal(fmt.Errorf("failed to create event producer: %w", err))
+		}
+		eventProducer = p
+		events = service.NewKafkaEventPublisher(p, cfg.Events.Topic)
+
+		go func() {
+			for e := range p.Events() {
+				if ev, ok := e.(*kafka.Message); ok && ev.TopicPartition.Error != nil {
+					log.Printf("failed to deliver event: %v", ev.TopicPartition)
+				}
+			}
+		}()
+	case "log":
+	default:
+		log.Fatalf("EVENTS.PUBLISHER %q is not supported", cfg.Events.Publisher)
+	}
+
 	if cfg.MODE == "productio" {
 		p, _ := kafka.NewProducer(&conf)
 		// topic := "notification"
@@ -96,13 +215,39 @@ func main() {
 		}()
 		go consumeKafka(userRepository, p)
 	}
-	go service.GrpcServer(cfg, &service.Server{UserRepository: userRepository, UserService: userService})
+
+	phoneChangeRepository := repository.NewPhoneChangeRepository(db)
+	phoneChangeService := service.NewPhoneChangeService(userRepository, phoneChangeRepository, otpService, events, cfg.Phone)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var background sync.WaitGroup
+	if cfg.OTPSweeper.Enabled {
+		otpSweeper := service.NewOTPSweeper(otpRepository, mfaRepository, refreshTokenRepository, revocationStore, otpSendStore, cfg.OTPSweeper, cfg.OTP)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			otpSweeper.Run(ctx)
+		}()
+	}
+
+	go service.GrpcServer(cfg, &service.Server{UserRepository: userRepository, UserService: userService, AddressService: addressService, Auth: auth, Phone: cfg.Phone, ServiceTokens: cfg.GRPCAuth.ServiceTokens})
 
 	// Initialize HTTP server with Gin
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal(fmt.Errorf("invalid trusted proxies: %w", err))
+	}
 	handler := handlers.NewHandler(&userService)
 	otp_handler := handlers.NewOTPHandler(&otpService)
 	address_handler := handlers.NewAddressHandler(&addressService)
+	jwks_handler := handlers.NewJWKSHandler(keys)
+	token_handler := handlers.NewTokenHandler(&tokenService)
+	role_handler := handlers.NewRoleHandler(&roleService)
+	password_handler := handlers.NewPasswordHandler(&passwordService)
+	lockout_handler := handlers.NewLockoutHandler(&lockoutService)
+	mfa_handler := handlers.NewMFAHandler(&mfaService)
+	phone_change_handler := handlers.NewPhoneChangeHandler(&phoneChangeService)
 
 	// Prometheus metrics endpoint
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
@@ -114,25 +259,84 @@ func main() {
 		})
 	}))
 	router.POST("/", measureMetrics("/", "POST", handler.CreateUser))
-	router.GET("/:id", measureMetrics("/:id", "GET", handler.GetUserByID))
-	router.PUT("/", measureMetrics("/", "PUT", handler.UpdateUser))
-	router.GET("/email/:email", measureMetrics("/email/:email", "GET", handler.GetUserByEmail))
+	router.GET("/:id", auth.JwtMiddleware, middlewares.RequireSelfOrRole("id", models.RoleAdmin), measureMetrics("/:id", "GET", handler.GetUserByID))
+	router.PUT("/", auth.JwtMiddleware, measureMetrics("/", "PUT", handler.UpdateUser))
+	router.GET("/email/:email", auth.JwtMiddleware, middlewares.RequireRole(models.RoleAdmin), measureMetrics("/email/:email", "GET", handler.GetUserByEmail))
 	router.POST("/signin", measureMetrics("/signin", "POST", handler.UserSignIn))
+	router.POST("/signin/mfa", measureMetrics("/signin/mfa", "POST", mfa_handler.SignIn))
+	router.POST("/token/refresh", measureMetrics("/token/refresh", "POST", token_handler.RefreshToken))
+	router.GET("/.well-known/jwks.json", measureMetrics("/.well-known/jwks.json", "GET", jwks_handler.GetJWKS))
 
 	router.POST("/otp", measureMetrics("/otp", "POST", otp_handler.GenerateOTP))
 	router.POST("/otp/verify", measureMetrics("/otp/verify", "POST", otp_handler.VerifyOTP))
 
-	router.POST("/address", measureMetrics("/address", "POST", address_handler.CreateAddress))
-	router.GET("/address/:id", measureMetrics("/address/:id", "GET", address_handler.GetAddressByID))
-	router.GET("/address/user/:user_id", measureMetrics("/address/user/:user_id", "GET", address_handler.GetAddressesByUserID))
-	router.PUT("/address", measureMetrics("/address", "PUT", address_handler.UpdateAddress))
+	router.POST("/email/otp", measureMetrics("/email/otp", "POST", otp_handler.GenerateEmailOTP))
+	router.POST("/email/otp/verify", measureMetrics("/email/otp/verify", "POST", otp_handler.VerifyEmailOTP))
+	router.POST("/email/magic-link", measureMetrics("/email/magic-link", "POST", otp_handler.SendMagicLink))
+	router.GET("/email/magic-link/verify", measureMetrics("/email/magic-link/verify", "GET", otp_handler.ShowMagicLink))
+	router.POST("/email/magic-link/verify", measureMetrics("/email/magic-link/verify", "POST", otp_handler.VerifyMagicLink))
+	router.GET("/email/change/verify", measureMetrics("/email/change/verify", "GET", otp_handler.ShowEmailChange))
+	router.POST("/email/change/verify", measureMetrics("/email/change/verify", "POST", otp_handler.ConfirmEmailChange))
+
+	router.POST("/password/forgot", measureMetrics("/password/forgot", "POST", password_handler.ForgotPassword))
+	router.POST("/password/reset", measureMetrics("/password/reset", "POST", password_handler.ResetPassword))
+
+	router.POST("/address", auth.JwtMiddleware, measureMetrics("/address", "POST", address_handler.CreateAddress))
+	router.GET("/address/:id", auth.JwtMiddleware, measureMetrics("/address/:id", "GET", address_handler.GetAddressByID))
+	router.GET("/address/user/:user_id", auth.JwtMiddleware, measureMetrics("/address/user/:user_id", "GET", address_handler.GetAddressesByUserID))
+	router.PUT("/address", auth.JwtMiddleware, measureMetrics("/address", "PUT", address_handler.UpdateAddress))
 
-	router.Use(middlewares.JwtMiddleware)
+	router.Use(auth.JwtMiddleware)
 	router.GET("/jwt", measureMetrics("/jwt", "GET", handler.GetUserWithJWT))
+	router.POST("/logout", measureMetrics("/logout", "POST", token_handler.Logout))
+	router.POST("/logout/all", measureMetrics("/logout/all", "POST", token_handler.LogoutAll))
+	router.PUT("/password", measureMetrics("/password", "PUT", password_handler.ChangePassword))
+	router.POST("/email/change", measureMetrics("/email/change", "POST", otp_handler.RequestEmailChange))
+	router.POST("/phone", measureMetrics("/phone", "POST", phone_change_handler.StartPhoneChange))
+	router.POST("/phone/verify", measureMetrics("/phone/verify", "POST", phone_change_handler.ConfirmPhoneChange))
+
+	router.POST("/mfa/totp", measureMetrics("/mfa/totp", "POST", mfa_handler.EnrollTOTP))
+	router.POST("/mfa/totp/confirm", measureMetrics("/mfa/totp/confirm", "POST", mfa_handler.ConfirmTOTP))
+	router.DELETE("/mfa/totp", measureMetrics("/mfa/totp", "DELETE", mfa_handler.DisableTOTP))
+	router.POST("/mfa/recovery-codes", measureMetrics("/mfa/recovery-codes", "POST", mfa_handler.RegenerateRecoveryCodes))
+
+	router.GET("/roles", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/roles", "GET", role_handler.GetRoles))
+	router.POST("/users/batch", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/users/batch", "POST", handler.BatchGetUsers))
+	router.POST("/users/:id/roles", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/users/:id/roles", "POST", role_handler.GrantRole))
+	router.DELETE("/users/:id/roles/:role", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/users/:id/roles/:role", "DELETE", role_handler.RevokeRole))
+
+	router.GET("/lockouts", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/lockouts", "GET", lockout_handler.GetLockouts))
+	router.DELETE("/lockouts/account/:user_id", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/lockouts/account/:user_id", "DELETE", lockout_handler.ClearAccountLockout))
+	router.DELETE("/lockouts/ip/:ip", middlewares.RequireRole(models.RoleAdmin), measureMetrics("/lockouts/ip/:ip", "DELETE", lockout_handler.ClearIPLockout))
 
 	// Start server
-	router.Run(fmt.Sprintf(":%s", cfg.HTTPPort))
+	server := &http.Server{Addr: fmt.Sprintf(":%s", cfg.HTTPPort), Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(fmt.Errorf("failed to start http server: %w", err))
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down http server: %v", err)
+	}
 
+	background.Wait()
+
+	// Events are produced asynchronously, so give the ones still queued until
+	// the shutdown deadline to be delivered.
+	if eventProducer != nil {
+		deadline, _ := shutdownCtx.Deadline()
+		if left := eventProducer.Flush(int(time.Until(deadline).Milliseconds())); left > 0 {
+			log.Printf("failed to deliver %d events before shutting down", left)
+		}
+		eventProducer.Close()
+	}
 }
 
 func measureMetrics(path string, method string, handlerFunc gin.HandlerFunc) gin.HandlerFunc {
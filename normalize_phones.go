@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tanush-128/openzo_backend/user/internal/models"
+	"github.com/tanush-128/openzo_backend/user/internal/phonenumber"
+	"gorm.io/gorm"
+)
+
+// normalizePhones rewrites the phone numbers of existing users and OTPs in
+// E.164 form. Users whose numbers normalize to the same number are reported
+// and left unchanged so the accounts can be merged by hand, as are numbers
+// that can't be read. With -dry-run nothing is written.
+func normalizePhones(db *gorm.DB, region string, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("normalize-phones", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report changes without writing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var users []models.User
+	if err := db.Select("id", "phone").Where("phone <> ''").Find(&users).Error; err != nil {
+		return err
+	}
+
+	byPhone := map[string][]models.User{}
+	invalid := 0
+	for _, user := range users {
+		normalized, err := phonenumber.Normalize(user.Phone, region)
+		if err != nil {
+			fmt.Fprintf(out, "invalid: user %s has phone %q\n", user.ID, user.Phone)
+			invalid++
+			continue
+		}
+		byPhone[normalized] = append(byPhone[normalized], user)
+	}
+
+	phones := make([]string, 0, len(byPhone))
+	for phone := range byPhone {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+
+	updated, collisions := 0, 0
+	for _, phone := range phones {
+		group := byPhone[phone]
+		if len(group) > 1 {
+			fmt.Fprintf(out, "collision: %s is used by", phone)
+			for _, user := range group {
+				fmt.Fprintf(out, " %s (%q)", user.ID, user.Phone)
+			}
+			fmt.Fprintln(out)
+			collisions++
+			continue
+		}
+
+		user := group[0]
+		if user.Phone == phone {
+			continue
+		}
+		fmt.Fprintf(out, "update: user %s %q -> %s\n", user.ID, user.Phone, phone)
+		if !*dryRun {
+			if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("phone", phone).Error; err != nil {
+				return err
+			}
+		}
+		updated++
+	}
+
+	var otps []models.OTP
+	if err := db.Select("id", "phone").Where("phone <> ''").Find(&otps).Error; err != nil {
+		return err
+	}
+
+	// OTPs only live for minutes, so ones that can't be normalized are
+	// deleted rather than reported.
+	otpsUpdated := 0
+	for _, otp := range otps {
+		normalized, err := phonenumber.Normalize(otp.Phone, region)
+		if normalized == otp.Phone {
+			continue
+		}
+		if !*dryRun {
+			var tx *gorm.DB
+			if err != nil {
+				tx = db.Where("id = ?", otp.ID).Delete(&models.OTP{})
+			} else {
+				tx = db.Model(&models.OTP{}).Where("id = ?", otp.ID).Update("phone", normalized)
+			}
+			if tx.Error != nil {
+				return tx.Error
+			}
+		}
+		otpsUpdated++
+	}
+
+	fmt.Fprintf(out, "%d users updated, %d collisions, %d invalid, %d OTPs updated or deleted", updated, collisions, invalid, otpsUpdated)
+	if *dryRun {
+		fmt.Fprint(out, " (dry run)")
+	}
+	fmt.Fprintln(out)
+
+	return nil
+}